@@ -6,6 +6,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"github.com/dikey0ficial/overmsg-client/internal/proto"
 	"github.com/sqweek/dialog"
 	"io/ioutil"
 	"net"
@@ -16,12 +17,6 @@ import (
 )
 
 var (
-	// TCPServerURL _
-	TCPServerURL string
-	// HTTPServerURL _
-	HTTPServerURL string
-	// TCPConn _
-	TCPConn         net.Conn
 	closeHbErrChRCh = make(chan struct{})
 )
 
@@ -32,32 +27,41 @@ func initAPI() {
 		dialog.Message("Error finding servers").Title("Error!!1").Error()
 		os.Exit(1)
 	}
-	if conf.Name != "" && conf.Token != "" {
+	if n, err := getMaxMessageLen(); err == nil && n > 0 && n < store.MaxMessageLen() {
+		store.SetMaxMessageLen(n)
+	}
+	if store.Name() != "" && store.Token() != "" {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
-		var hbErrCh chan error
-		TCPConn, hbErrCh, err = connectTCP(ctx, conf.Token)
+		conn, hbErrCh, err := connectTCP(ctx, store.Token())
 		if err != nil {
 			errl.Println(err)
 			dialog.Message("Error connecting to server (more info in log). " +
 				"This may be if server is offline or you are already online with in other app").Title("Error!!1").Error()
 			os.Exit(1)
 		}
+		store.SetConn(conn)
 		closeHbErrChRCh = make(chan struct{})
-		go func(hbErrCh chan error) {
+		go func(hbErrCh chan error, stop chan struct{}) {
 			for {
-				if conf.Token == "" {
+				if store.Token() == "" {
 					return
 				}
 				select {
 				case err := <-hbErrCh:
 					if err != nil {
-						_, err := TCPConn.Read(make([]byte, 1))
-						if TCPConn == nil || err != nil {
+						conn := store.Conn()
+						if conn != nil {
+							_, err = conn.Read(make([]byte, 1))
+						}
+						if conn == nil || err != nil {
 							errl.Println(err)
-							if _, err := ping(ctx, HTTPServerURL); err != nil {
+							store.SetConnected(false)
+							if _, err := ping(ctx, store.HTTPURL()); err != nil {
 								errl.Println(err)
-								TCPConn, hbErrCh, err = connectTCP(ctx, conf.Token)
+								var conn net.Conn
+								conn, hbErrCh, err = connectTCP(ctx, store.Token())
+								store.SetConn(conn)
 								if err != nil {
 									errl.Println(err)
 									dialog.Message("Error: can't connect to server").Title("Error!!1").Error()
@@ -65,11 +69,11 @@ func initAPI() {
 							}
 						}
 					}
-				case <-closeHbErrChRCh:
+				case <-stop:
 					return
 				}
 			}
-		}(hbErrCh)
+		}(hbErrCh, closeHbErrChRCh)
 	}
 }
 
@@ -93,20 +97,21 @@ func tryGetURLs() error {
 	if bestURL == "" {
 		return errors.New("Found no aviable servers in list of servers")
 	}
-	HTTPServerURL, TCPServerURL = "http://"+bestURL+":4422", bestURL+":4242"
+	store.SetServers("http://"+bestURL+":4422", bestURL+":4242")
 	return nil
 }
 
 func connectTCP(ctx context.Context, token string) (net.Conn, chan error, error) {
 	var d net.Dialer
-	conn, err := d.DialContext(ctx, "tcp", TCPServerURL)
+	_, tcpURL := store.Servers()
+	conn, err := d.DialContext(ctx, "tcp", tcpURL)
 	if err != nil {
 		return nil, nil, err
 	}
 	conn.Write([]byte(token + "\n"))
 	errCh := make(chan error, 0)
 	go heartbeat(conn, errCh)
-	go getMsgsAPI()
+	go getMsgsAPI(conn)
 	return conn, errCh, nil
 }
 
@@ -114,58 +119,76 @@ func heartbeat(conn net.Conn, ech chan error) {
 	t := time.NewTicker(30 * time.Second)
 	for {
 		<-t.C
-		_, err := http.Post(HTTPServerURL+"/heartbeat", "text/plain", strings.NewReader(conf.Token))
+		_, err := http.Post(store.HTTPURL()+"/heartbeat", "text/plain", strings.NewReader(store.Token()))
 		ech <- err
 	}
 }
 
-func getMsgsAPI() {
-	for {
-		if TCPConn == nil {
-			time.Sleep(2 * time.Second)
+// getMsgsAPI reads frames from connection until it's closed. Messages are put
+// to messCh; when it's full, reading waits (so it's back-pressure for server)
+func getMsgsAPI(conn net.Conn) {
+	in := bufio.NewScanner(conn)
+	for in.Scan() {
+		if in.Err() != nil {
+			errl.Println(in.Err())
+			dialog.Message("Error getting info from server (more info in logs) :(")
 			continue
 		}
-		in := bufio.NewScanner(TCPConn)
-		for in.Scan() {
-			if in.Err() != nil {
-				errl.Println(in.Err())
-				dialog.Message("Error getting info from server (more info in logs) :(")
+		var got map[string]interface{}
+		if err := json.Unmarshal(in.Bytes(), &got); err != nil {
+			if strings.TrimSpace(in.Text()) == "success" {
 				continue
 			}
-			var got map[string]interface{}
-			if err := json.Unmarshal(in.Bytes(), &got); err != nil {
-				if strings.TrimSpace(in.Text()) == "success" {
-					continue
-				}
-				errl.Printf("%s (%v)\n", in.Text(), err)
+			errl.Printf("%s (%v)\n", in.Text(), err)
+			dialog.Message("Error getting info from server (more info in logs) :(")
+			continue
+		}
+		var t string
+		if el, ok := got["type"]; !ok {
+			continue
+		} else if t, ok = el.(string); !ok {
+			continue
+		}
+		switch t {
+		case "message":
+			var mess message
+			if err := json.Unmarshal(in.Bytes(), &mess); err != nil {
+				errl.Println(err)
 				dialog.Message("Error getting info from server (more info in logs) :(")
 				continue
 			}
-			var t string
-			if el, ok := got["type"]; !ok {
+			if mess.Error != "" {
+				errl.Println(mess.Error)
+				dialog.Message("Error getting info from server (more info in logs) :(")
 				continue
-			} else if t, ok = el.(string); !ok {
+			}
+			mess.got = time.Now()
+			messCh <- mess
+		case "typing":
+			var mess message
+			if err := json.Unmarshal(in.Bytes(), &mess); err != nil {
+				errl.Println(err)
 				continue
 			}
-			switch t {
-			case "message":
-				var mess message
-				if err := json.Unmarshal(in.Bytes(), &mess); err != nil {
-					errl.Println(err)
-					dialog.Message("Error getting info from server (more info in logs) :(")
-					continue
-				}
-				if mess.Error != "" {
-					errl.Println(mess.Error)
-					dialog.Message("Error getting info from server (more info in logs) :(")
-					continue
-				}
-				messCh <- mess
-			default:
+			mess.got = time.Now()
+			messCh <- mess
+		case "presence":
+			var pf presenceFrame
+			if err := json.Unmarshal(in.Bytes(), &pf); err != nil {
+				errl.Println(err)
 				continue
 			}
+			presence.Set(pf.Name, pf.Online)
+		default:
+			continue
 		}
 	}
+	if err := in.Err(); err != nil {
+		errl.Println(err)
+	}
+	if store.Conn() == conn { // else it was already replaced
+		store.SetConnected(false)
+	}
 }
 
 func ping(ctx context.Context, url string) (time.Duration, error) {
@@ -192,8 +215,33 @@ func ping(ctx context.Context, url string) (time.Duration, error) {
 
 var client = &http.Client{}
 
+// getMaxMessageLen asks server for it's limit of message length.
+// Returns 0 if server doesn't tell it
+func getMaxMessageLen() (int, error) {
+	resp, err := client.Get(store.HTTPURL())
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	dat, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	var ans proto.Answer
+	if err := json.Unmarshal(dat, &ans); err != nil {
+		return 0, nil // old servers answer not with json
+	}
+	if elem, ok := ans.Res["max_message_len"]; ok {
+		if n, ok := elem.(float64); ok {
+			return int(n), nil
+		}
+		return 0, errors.New("got not-number max_message_len")
+	}
+	return 0, nil
+}
+
 func reg(name, pass string) (string, error) {
-	req, err := http.NewRequest("POST", HTTPServerURL+"/reg",
+	req, err := http.NewRequest("POST", store.HTTPURL()+"/reg",
 		strings.NewReader(`{"name":"`+name+`","pass":"`+pass+`"}`),
 	)
 	if err != nil {
@@ -209,7 +257,7 @@ func reg(name, pass string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	var ans answer
+	var ans proto.Answer
 	if err := json.Unmarshal(dat, &ans); err != nil {
 		return "", err
 	}
@@ -234,7 +282,7 @@ func reg(name, pass string) (string, error) {
 }
 
 func getToken(name, pass string) (string, error) {
-	req, err := http.NewRequest("POST", HTTPServerURL+"/get_token",
+	req, err := http.NewRequest("POST", store.HTTPURL()+"/get_token",
 		strings.NewReader(`{"name":"`+name+`","pass":"`+pass+`"}`),
 	)
 	if err != nil {
@@ -250,7 +298,7 @@ func getToken(name, pass string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	var ans answer
+	var ans proto.Answer
 	if err := json.Unmarshal(dat, &ans); err != nil {
 		return "", err
 	}
@@ -275,7 +323,7 @@ func getToken(name, pass string) (string, error) {
 }
 
 func goOffline(token string) error {
-	req, err := http.NewRequest("POST", HTTPServerURL+"/go_offline", nil)
+	req, err := http.NewRequest("POST", store.HTTPURL()+"/go_offline", nil)
 	if err != nil {
 		return err
 	}
@@ -289,14 +337,17 @@ func goOffline(token string) error {
 	if err != nil {
 		return err
 	}
-	var ans answer
+	var ans proto.Answer
 	if err := json.Unmarshal(dat, &ans); err != nil {
 		return err
 	}
 	if !ans.Success {
 		return errors.New(ans.Error)
 	}
-	TCPConn.Close()
+	if conn := store.Conn(); conn != nil {
+		conn.Close()
+	}
+	store.SetConn(nil)
 	closeHbErrChRCh <- struct{}{}
 	close(closeHbErrChRCh)
 	return nil
@@ -310,7 +361,7 @@ func sendMessage(token, msg, to string) error {
 	if err != nil {
 		return err
 	}
-	req, err := http.NewRequest("POST", HTTPServerURL+"/send_message",
+	req, err := http.NewRequest("POST", store.HTTPURL()+"/send_message",
 		bytes.NewReader(body),
 	)
 	req.Header.Add("Content-Type", "application/json")
@@ -324,7 +375,7 @@ func sendMessage(token, msg, to string) error {
 	if err != nil {
 		return err
 	}
-	var ans answer
+	var ans proto.Answer
 	if err := json.Unmarshal(dat, &ans); err != nil {
 		return err
 	}
@@ -334,6 +385,15 @@ func sendMessage(token, msg, to string) error {
 	return nil
 }
 
+// sendEnvelope sends text with envelope after it
+func sendEnvelope(token, txt, to string, env envelope) error {
+	msg, err := proto.Encode(txt, env)
+	if err != nil {
+		return err
+	}
+	return sendMessage(token, msg, to)
+}
+
 func isOnline(nick string) (bool, bool, error) {
 	body, err := json.Marshal(isOnlineReq{
 		Name: nick,
@@ -341,7 +401,7 @@ func isOnline(nick string) (bool, bool, error) {
 	if err != nil {
 		return false, false, err
 	}
-	resp, err := http.Post(HTTPServerURL+"/is_online", "application/json", bytes.NewReader(body))
+	resp, err := http.Post(store.HTTPURL()+"/is_online", "application/json", bytes.NewReader(body))
 	if err != nil {
 		return false, false, err
 	}
@@ -350,7 +410,7 @@ func isOnline(nick string) (bool, bool, error) {
 	if err != nil {
 		return false, false, err
 	}
-	var ans answer
+	var ans proto.Answer
 	if err := json.Unmarshal(dat, &ans); err != nil {
 		return false, false, err
 	}
@@ -0,0 +1,37 @@
+package main
+
+// isBlocked returns true if messages from nick must be hidden; they are kept only in history
+func isBlocked(nick string) bool {
+	var blocked bool
+	store.ReadConf(func() {
+		for _, b := range conf.Blocked {
+			if b == nick {
+				blocked = true
+				return
+			}
+		}
+	})
+	return blocked
+}
+
+// block adds nick to block list and saves config
+func block(nick string) error {
+	if isBlocked(nick) {
+		return nil
+	}
+	store.Conf(func() { conf.Blocked = append(conf.Blocked, nick) })
+	return saveConf()
+}
+
+// unblock removes nick from block list and saves config
+func unblock(nick string) error {
+	store.Conf(func() {
+		for i, b := range conf.Blocked {
+			if b == nick {
+				conf.Blocked = append(conf.Blocked[:i], conf.Blocked[i+1:]...)
+				break
+			}
+		}
+	})
+	return saveConf()
+}
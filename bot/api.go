@@ -0,0 +1,138 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/dikey0ficial/overmsg-client/internal/proto"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	httpPort = "4422"
+	tcpPort  = "4242"
+)
+
+// frame is line got from TCP stream
+type frame struct {
+	Type    string `json:"type"`
+	From    string `json:"from_name"`
+	Message string `json:"message"`
+	Error   string `json:"error,omitempty"`
+}
+
+// post sends JSON to server and decodes it's answer
+func (b *Bot) post(ctx context.Context, path string, body interface{}, token string) (proto.Answer, error) {
+	var ans proto.Answer
+	var rd *bytes.Reader
+	if body != nil {
+		dat, err := json.Marshal(body)
+		if err != nil {
+			return ans, err
+		}
+		rd = bytes.NewReader(dat)
+	} else {
+		rd = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequest("POST", b.httpURL+path, rd)
+	if err != nil {
+		return ans, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Auth-Token", token)
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return ans, err
+	}
+	defer resp.Body.Close()
+	dat, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return ans, err
+	}
+	if err := json.Unmarshal(dat, &ans); err != nil {
+		return ans, err
+	}
+	if !ans.Success {
+		return ans, errors.New(ans.Error)
+	}
+	return ans, nil
+}
+
+// getToken logs in and returns token of account
+func (b *Bot) getToken(ctx context.Context, name, pass string) (string, error) {
+	ans, err := b.post(ctx, "/get_token", map[string]string{"name": name, "pass": pass}, "")
+	if err != nil {
+		return "", err
+	}
+	token, ok := ans.Res["token"].(string)
+	if !ok {
+		return "", errors.New("got no token")
+	}
+	if token = strings.TrimSpace(token); token == "" {
+		return "", errors.New("got empty token")
+	}
+	return token, nil
+}
+
+// sendMessage sends raw text to peer
+func (b *Bot) sendMessage(ctx context.Context, msg, to string) error {
+	_, err := b.post(ctx, "/send_message", map[string]string{"peer_name": to, "message": msg}, b.token)
+	return err
+}
+
+// isOnline tells if user is online and if it exists
+func (b *Bot) isOnline(ctx context.Context, nick string) (bool, bool, error) {
+	ans, err := b.post(ctx, "/is_online", map[string]string{"name": nick}, "")
+	if err != nil {
+		return false, false, err
+	}
+	is, ok := ans.Res["is"].(bool)
+	if !ok {
+		return false, false, errors.New("got no is")
+	}
+	exists, ok := ans.Res["exists"].(bool)
+	if !ok {
+		return false, false, errors.New("got no exists")
+	}
+	return is, exists, nil
+}
+
+// heartbeat tells server that bot is still here
+func (b *Bot) heartbeat(ctx context.Context) error {
+	req, err := http.NewRequest("POST", b.httpURL+"/heartbeat", strings.NewReader(b.token))
+	if err != nil {
+		return err
+	}
+	resp, err := b.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// goOffline tells server that bot leaves
+func (b *Bot) goOffline(ctx context.Context) error {
+	_, err := b.post(ctx, "/go_offline", nil, b.token)
+	return err
+}
+
+// connect opens TCP stream of messages
+func (b *Bot) connect(ctx context.Context) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", b.tcpAddr)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write([]byte(b.token + "\n")); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,333 @@
+// Package bot is framework for overmsg bots: it logs in, keeps connection
+// with server, calls handlers for got messages and routes commands
+package bot
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/dikey0ficial/overmsg-client/internal/proto"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	heartbeatInterval = 30 * time.Second
+	maxReconnectDelay = time.Minute
+	shutdownTimeout   = 5 * time.Second
+	// dedupTTL is how long ids of got messages are remembered, so messages
+	// which server resent after reconnection are handled once
+	dedupTTL = 15 * time.Minute
+)
+
+var errNotLogged = errors.New("bot isn't logged in")
+
+// Message is got text message
+type Message struct {
+	ID      string // empty if peer's client doesn't send envelopes
+	From    string
+	Text    string
+	ReplyTo string
+	Time    time.Time
+	bot     *Bot
+}
+
+// Reply sends text to author of message
+func (m *Message) Reply(txt string) error {
+	return m.bot.Send(m.From, txt)
+}
+
+// State returns conversation state of message's author
+func (m *Message) State() State {
+	return m.bot.State(m.From)
+}
+
+// Handler handles got message
+type Handler func(m *Message)
+
+// CommandHandler handles command; args are words after it, quotes group words
+type CommandHandler func(m *Message, args []string)
+
+// State is data of conversation with one peer; handlers are called one by one,
+// so it may be used in them without locks
+type State map[string]interface{}
+
+// Bot is overmsg bot
+type Bot struct {
+	// Prefix starts commands, "/" by default
+	Prefix string
+	// Log gets errors of connection and sending
+	Log *log.Logger
+
+	name     string
+	token    string
+	httpURL  string
+	tcpAddr  string
+	client   *http.Client
+	handlers []Handler
+	commands map[string]CommandHandler
+	states   map[string]State
+	seen     map[string]time.Time // ids of got messages
+	pruned   time.Time
+	mu       sync.Mutex // guards states and seen
+}
+
+// New returns bot working with server; server is host like in client's server_urls
+func New(server string) *Bot {
+	return &Bot{
+		Prefix:   "/",
+		Log:      log.New(os.Stderr, "[BOT]\t", log.Ldate|log.Ltime),
+		httpURL:  "http://" + net.JoinHostPort(server, httpPort),
+		tcpAddr:  net.JoinHostPort(server, tcpPort),
+		client:   &http.Client{Timeout: 30 * time.Second},
+		commands: make(map[string]CommandHandler),
+		states:   make(map[string]State),
+		seen:     make(map[string]time.Time),
+	}
+}
+
+// Login gets token of account
+func (b *Bot) Login(ctx context.Context, name, pass string) error {
+	token, err := b.getToken(ctx, name, pass)
+	if err != nil {
+		return err
+	}
+	b.name, b.token = name, token
+	return nil
+}
+
+// LoginToken uses token got before, e.g. from client's config
+func (b *Bot) LoginToken(name, token string) {
+	b.name, b.token = name, token
+}
+
+// Name returns name of bot's account
+func (b *Bot) Name() string {
+	return b.name
+}
+
+// OnMessage adds handler of messages which aren't known commands
+func (b *Bot) OnMessage(h Handler) {
+	b.handlers = append(b.handlers, h)
+}
+
+// Command adds handler of command name; it must be called before Run
+func (b *Bot) Command(name string, h CommandHandler) {
+	b.commands[name] = h
+}
+
+// State returns conversation state of peer
+func (b *Bot) State(peer string) State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st, ok := b.states[peer]
+	if !ok {
+		st = make(State)
+		b.states[peer] = st
+	}
+	return st
+}
+
+// ResetState forgets conversation with peer
+func (b *Bot) ResetState(peer string) {
+	b.mu.Lock()
+	delete(b.states, peer)
+	b.mu.Unlock()
+}
+
+// Send sends text to peer
+func (b *Bot) Send(to, txt string) error {
+	if b.token == "" {
+		return errNotLogged
+	}
+	msg, err := proto.Encode(txt, proto.Envelope{V: proto.EnvelopeVersion, ID: proto.NewMessageID(), Type: proto.TypeText})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return b.sendMessage(ctx, msg, to)
+}
+
+// IsOnline tells if user is online and if it exists
+func (b *Bot) IsOnline(ctx context.Context, nick string) (online, exists bool, err error) {
+	return b.isOnline(ctx, nick)
+}
+
+// Run gets messages and calls handlers until ctx is done; then bot goes offline.
+// Lost connection is opened again
+func (b *Bot) Run(ctx context.Context) error {
+	if b.token == "" {
+		return errNotLogged
+	}
+	go b.heartbeats(ctx)
+	delay := time.Second
+	for {
+		conn, err := b.connect(ctx)
+		if err == nil {
+			delay = time.Second
+			err = b.read(ctx, conn)
+		}
+		if ctx.Err() != nil {
+			return b.shutdown()
+		}
+		b.Log.Println(err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return b.shutdown()
+		}
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// shutdown tells server that bot leaves
+func (b *Bot) shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return b.goOffline(ctx)
+}
+
+func (b *Bot) heartbeats(ctx context.Context) {
+	t := time.NewTicker(heartbeatInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := b.heartbeat(ctx); err != nil && ctx.Err() == nil {
+				b.Log.Println(err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// read handles frames of connection until it's closed
+func (b *Bot) read(ctx context.Context, conn net.Conn) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+	defer conn.Close()
+	in := bufio.NewScanner(conn)
+	in.Buffer(nil, 1<<20)
+	for in.Scan() {
+		var f frame
+		if err := json.Unmarshal(in.Bytes(), &f); err != nil {
+			continue // e.g. "success" after token
+		}
+		if f.Type != "message" {
+			continue
+		}
+		if f.Error != "" {
+			b.Log.Println(f.Error)
+			continue
+		}
+		b.dispatch(f)
+	}
+	if err := in.Err(); err != nil {
+		return err
+	}
+	return errors.New("connection is closed by server")
+}
+
+// dispatch calls handlers of message
+func (b *Bot) dispatch(f frame) {
+	m := &Message{From: f.From, Time: time.Now(), bot: b}
+	txt, env := proto.Decode(f.Message)
+	m.Text = txt
+	if env != nil {
+		if env.Type != proto.TypeText {
+			return // receipts, typing, files and so on
+		}
+		if b.isDup(env.ID) {
+			return
+		}
+		m.ID, m.ReplyTo = env.ID, env.ReplyTo
+	}
+	if name, args, ok := b.parseCommand(m.Text); ok {
+		if h, ok := b.commands[name]; ok {
+			h(m, args)
+			return
+		}
+	}
+	for _, h := range b.handlers {
+		h(m)
+	}
+}
+
+// isDup returns true if message with id was got during dedupTTL and remembers id
+func (b *Bot) isDup(id string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if now.Sub(b.pruned) > dedupTTL/10 {
+		for k, t := range b.seen {
+			if now.Sub(t) > dedupTTL {
+				delete(b.seen, k)
+			}
+		}
+		b.pruned = now
+	}
+	if t, ok := b.seen[id]; ok && now.Sub(t) <= dedupTTL {
+		return true
+	}
+	b.seen[id] = now
+	return false
+}
+
+// parseCommand splits text to command and it's arguments
+func (b *Bot) parseCommand(txt string) (string, []string, bool) {
+	if b.Prefix == "" || !strings.HasPrefix(txt, b.Prefix) {
+		return "", nil, false
+	}
+	words := SplitArgs(strings.TrimPrefix(txt, b.Prefix))
+	if len(words) == 0 {
+		return "", nil, false
+	}
+	return words[0], words[1:], true
+}
+
+// SplitArgs splits text by spaces; text in double quotes is one argument
+func SplitArgs(txt string) []string {
+	var (
+		args   []string
+		cur    strings.Builder
+		quoted bool
+		inArg  bool
+	)
+	for _, r := range txt {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inArg = true
+		case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args
+}
@@ -0,0 +1,69 @@
+package bot
+
+import (
+	"github.com/dikey0ficial/overmsg-client/internal/proto"
+	"reflect"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"one", []string{"one"}},
+		{"one  two\tthree\nfour", []string{"one", "two", "three", "four"}},
+		{`say "hello world" now`, []string{"say", "hello world", "now"}},
+		{`""`, []string{""}},
+		{`a"b c"d`, []string{"ab cd"}},
+		{`"unclosed quote`, []string{"unclosed quote"}},
+	}
+	for _, tt := range tests {
+		if got := SplitArgs(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SplitArgs(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		prefix, in string
+		name       string
+		args       []string
+		ok         bool
+	}{
+		{"/", "/help", "help", []string{}, true},
+		{"/", `/say "hi there" you`, "say", []string{"hi there", "you"}, true},
+		{"/", "hello", "", nil, false},
+		{"/", "/", "", nil, false},
+		{"/", "/   ", "", nil, false},
+		{"!", "!count", "count", []string{}, true},
+		{"!", "/count", "", nil, false},
+		{"", "/count", "", nil, false},
+	}
+	for _, tt := range tests {
+		b := &Bot{Prefix: tt.prefix}
+		name, args, ok := b.parseCommand(tt.in)
+		if name != tt.name || ok != tt.ok || len(args) != len(tt.args) || (len(args) != 0 && !reflect.DeepEqual(args, tt.args)) {
+			t.Errorf("parseCommand(%q) with prefix %q = %q, %q, %v, want %q, %q, %v",
+				tt.in, tt.prefix, name, args, ok, tt.name, tt.args, tt.ok)
+		}
+	}
+}
+
+func TestDispatchDedup(t *testing.T) {
+	b := New("localhost")
+	var got []string
+	b.OnMessage(func(m *Message) { got = append(got, m.Text) })
+	f := frame{Type: "message", From: "alice", Message: "hi\n" + proto.EnvelopePrefix + `{"v":1,"id":"a1","type":"text"}`}
+	b.dispatch(f)
+	b.dispatch(f) // server resent it after reconnection
+	b.dispatch(frame{Type: "message", From: "alice", Message: "old client"})
+	b.dispatch(frame{Type: "message", From: "alice", Message: "old client"})
+	want := []string{"hi", "old client", "old client"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("handled %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,60 @@
+// Echo is example bot: it answers with got text and counts messages of every peer.
+//
+//	OVERMSG_PASS=secret go run ./bot/examples/echo -server localhost -name echobot
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/dikey0ficial/overmsg-client/bot"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+func main() {
+	server := flag.String("server", "localhost", "host of overmsg server")
+	name := flag.String("name", "echobot", "name of bot's account")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	b := bot.New(*server)
+	if err := b.Login(ctx, *name, os.Getenv("OVERMSG_PASS")); err != nil {
+		log.Fatal(err)
+	}
+	b.Command("help", func(m *bot.Message, _ []string) {
+		m.Reply("I repeat what you write. Commands: /count, /reset, /say <words...>")
+	})
+	b.Command("count", func(m *bot.Message, _ []string) {
+		n, _ := m.State()["count"].(int)
+		m.Reply(fmt.Sprintf("You wrote me %d messages", n))
+	})
+	b.Command("reset", func(m *bot.Message, _ []string) {
+		b.ResetState(m.From)
+		m.Reply("Forgotten")
+	})
+	b.Command("say", func(m *bot.Message, args []string) {
+		if len(args) == 0 {
+			m.Reply("Usage: /say <words...>")
+			return
+		}
+		m.Reply(strings.Join(args, " "))
+	})
+	b.OnMessage(func(m *bot.Message) {
+		st := m.State()
+		n, _ := st["count"].(int)
+		st["count"] = n + 1
+		if err := m.Reply(m.Text); err != nil {
+			log.Println(err)
+		}
+	})
+	log.Printf("%s is running, press Ctrl+C to stop", b.Name())
+	if err := b.Run(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
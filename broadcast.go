@@ -0,0 +1,151 @@
+package main
+
+import (
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"github.com/sqweek/dialog"
+	"strings"
+	"sync"
+)
+
+// BroadcastAct is activity which sends one message to several contacts
+type BroadcastAct struct {
+	Invalidate func()
+	Input      material.EditorStyle
+	SendBtn    material.ButtonStyle
+	AllBtn     material.ButtonStyle
+	List       *widget.List
+	selected   map[string]*widget.Bool
+
+	mu sync.Mutex
+	// results are statuses of sending to every recipient of the last broadcast
+	results map[string]string
+	order   []string
+}
+
+// newBroadcastAct is constructor for BroadcastAct
+func newBroadcastAct(th T) *BroadcastAct {
+	return &BroadcastAct{
+		Invalidate: func() {},
+		Input:      material.Editor(th, new(widget.Editor), "Type your announcement here..."),
+		SendBtn:    material.Button(th, new(widget.Clickable), "Send to selected"),
+		AllBtn:     material.Button(th, new(widget.Clickable), "Select all"),
+		List:       &widget.List{List: layout.List{Axis: layout.Vertical}},
+		selected:   make(map[string]*widget.Bool),
+		results:    make(map[string]string),
+	}
+}
+
+func (ba *BroadcastAct) setResult(nick, res string) {
+	ba.mu.Lock()
+	ba.results[nick] = res
+	ba.mu.Unlock()
+	ba.Invalidate()
+}
+
+// send sends text to every selected contact through it's chat, so it's stored
+// in each history; it's called on UI goroutine
+func (ba *BroadcastAct) send(txt string, nicks []string) {
+	ba.mu.Lock()
+	ba.results, ba.order = make(map[string]string), nicks
+	ba.mu.Unlock()
+	for _, nick := range nicks {
+		nick := nick
+		c := GetByPN(store.Chats, nick)
+		if c.PeerName == "" {
+			c = newChat(nick)
+			store.Chats = append(store.Chats, c)
+		}
+		ba.setResult(nick, "sending...")
+		c.sendText(txt, "", func(id string, err error) {
+			if err != nil {
+				ba.setResult(nick, "failed: "+err.Error())
+				return
+			}
+			if outbox.Pending(nick)[id] { // peer is offline or server is unreachable
+				ba.setResult(nick, "waiting for peer")
+				return
+			}
+			ba.setResult(nick, "sent")
+		})
+	}
+}
+
+// Layout layouts composer, list of contacts and results
+func (ba *BroadcastAct) Layout(gtx C, th T) D {
+	list := contacts.All()
+	for _, c := range list {
+		if _, ok := ba.selected[c.Nick]; !ok {
+			ba.selected[c.Nick] = new(widget.Bool)
+		}
+	}
+	if ba.AllBtn.Button.Clicked() {
+		for _, c := range list {
+			ba.selected[c.Nick].Value = true
+		}
+	}
+	txt := strings.TrimSpace(ba.Input.Editor.Text())
+	if ba.SendBtn.Button.Clicked() {
+		var nicks []string
+		for _, c := range list {
+			if ba.selected[c.Nick].Value {
+				nicks = append(nicks, c.Nick)
+			}
+		}
+		switch {
+		case txt == "" || len(nicks) == 0:
+			dialog.Message("Type message and select recipients").Title("0_0").Info()
+		case len([]rune(txt)) > maxTextLen():
+			dialog.Message("Message is too long").Title("0_0").Info()
+		default:
+			ba.send(txt, nicks)
+			ba.Input.Editor.SetText("")
+		}
+	}
+	ba.mu.Lock()
+	order := ba.order
+	results := make(map[string]string, len(ba.results))
+	for k, v := range ba.results {
+		results[k] = v
+	}
+	ba.mu.Unlock()
+	return layout.UniformInset(unit.Dp(15)).Layout(gtx, func(gtx C) D {
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+			layout.Rigid(func(gtx C) D {
+				gtx.Constraints.Max.Y = gtx.Px(maxInputHeight)
+				return widget.Border{
+					Width:        unit.Dp(0.5),
+					Color:        th.Fg,
+					CornerRadius: unit.Dp(5),
+				}.Layout(gtx, func(gtx C) D {
+					gtx.Constraints.Min.X = gtx.Constraints.Max.X
+					return layout.UniformInset(unit.Dp(5)).Layout(gtx, ba.Input.Layout)
+				})
+			}),
+			hspacer,
+			layout.Rigid(func(gtx C) D {
+				return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+					layout.Rigid(ba.SendBtn.Layout),
+					wspacer,
+					layout.Rigid(ba.AllBtn.Layout),
+				)
+			}),
+			hspacer,
+			layout.Flexed(1, func(gtx C) D {
+				if len(list) == 0 {
+					return material.Body2(th, "You have no contacts").Layout(gtx)
+				}
+				return material.List(th, ba.List).Layout(gtx, len(list)+len(order), func(gtx C, ind int) D {
+					if ind < len(list) {
+						c := list[ind]
+						return material.CheckBox(th, ba.selected[c.Nick], contacts.DisplayName(c.Nick)).Layout(gtx)
+					}
+					nick := order[ind-len(list)]
+					return material.Body2(th, contacts.DisplayName(nick)+": "+results[nick]).Layout(gtx)
+				})
+			}),
+		)
+	})
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"github.com/dikey0ficial/overmsg-client/internal/proto"
+	"github.com/sqweek/dialog"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+// cmdPrefix starts command in message input; doubled it sends text as is
+const cmdPrefix = "/"
+
+// Command is slash command of message input
+type Command struct {
+	Name string
+	Args string // description of arguments, shown in autocompletion
+	Help string
+	// Run is called on UI goroutine; long work should be done in goroutine,
+	// with results shown by cmdContext.Reply
+	Run func(cc *cmdContext, args string)
+}
+
+// cmdContext is what command may use
+type cmdContext struct {
+	ui   *UI
+	ca   *ChatActivity
+	chat *Chat
+}
+
+// Reply shows local system message in chat where command was run;
+// it must be called on UI goroutine
+func (cc *cmdContext) Reply(format string, a ...interface{}) {
+	cc.chat.AddMessage(GUIMessage{ID: proto.NewMessageID(), Type: ctSystem, Text: fmt.Sprintf(format, a...), Time: time.Now()})
+}
+
+// Post is Reply for other goroutines; message is added with store.Do
+func (cc *cmdContext) Post(format string, a ...interface{}) {
+	txt := fmt.Sprintf(format, a...)
+	c := cc.chat
+	store.Do(func() {
+		c.AddMessage(GUIMessage{ID: proto.NewMessageID(), Type: ctSystem, Text: txt, Time: time.Now()})
+	})
+}
+
+var commands = make(map[string]*Command)
+
+// registerCommand adds command to registry; command with same name is replaced
+func registerCommand(c *Command) {
+	commands[c.Name] = c
+}
+
+// matchCommands returns commands whose names start with prefix, sorted by name
+func matchCommands(prefix string) []*Command {
+	var res []*Command
+	for name, c := range commands {
+		if strings.HasPrefix(name, prefix) {
+			res = append(res, c)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// parseCommand splits input to command name and arguments;
+// ok is false if input is not command
+func parseCommand(txt string) (name, args string, ok bool) {
+	if !strings.HasPrefix(txt, cmdPrefix) || strings.HasPrefix(txt, cmdPrefix+cmdPrefix) {
+		return "", "", false
+	}
+	txt = strings.TrimPrefix(txt, cmdPrefix)
+	if i := strings.IndexAny(txt, " \t\n"); i >= 0 {
+		return txt[:i], strings.TrimSpace(txt[i+1:]), true
+	}
+	return txt, "", true
+}
+
+// runCommand runs command from input; it returns false if txt is not command
+func runCommand(cc *cmdContext, txt string) bool {
+	name, args, ok := parseCommand(txt)
+	if !ok {
+		return false
+	}
+	c, ok := commands[name]
+	if !ok {
+		cc.Reply("Unknown command %s%s", cmdPrefix, name)
+		return true
+	}
+	c.Run(cc, args)
+	return true
+}
+
+func init() {
+	registerCommand(&Command{Name: "ping", Help: "measure time of request to server", Run: cmdPing})
+	registerCommand(&Command{Name: "whois", Args: "<nick>", Help: "tell if user exists and is online", Run: cmdWhois})
+	registerCommand(&Command{Name: "clear", Help: "clear chat window; history is kept", Run: cmdClear})
+	registerCommand(&Command{Name: "open", Args: "<nick>", Help: "open chat with user", Run: cmdOpen})
+	registerCommand(&Command{Name: "me", Args: "<action>", Help: "send action", Run: cmdMe})
+	registerCommand(&Command{Name: "export", Help: "save history of chat to text file", Run: cmdExport})
+	registerCommand(&Command{Name: "block", Help: "block or unblock peer", Run: cmdBlock})
+}
+
+func cmdPing(cc *cmdContext, _ string) {
+	go func() {
+		url := store.HTTPURL()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		dur, err := ping(ctx, url)
+		if err != nil {
+			errl.Println(err)
+			cc.Post("Ping of %s failed: %v", url, err)
+			return
+		}
+		cc.Post("Server: %s, time: %s", url, dur)
+	}()
+}
+
+func cmdWhois(cc *cmdContext, nick string) {
+	if !isSafeName(nick) {
+		cc.Reply("Usage: %swhois <nick>", cmdPrefix)
+		return
+	}
+	go func() {
+		is, exs, err := isOnline(nick)
+		switch {
+		case err != nil:
+			errl.Println(err)
+			cc.Post("Error asking server about %s", nick)
+		case !exs:
+			cc.Post("User %s doesn't exist", nick)
+		case is:
+			presence.Set(nick, true)
+			cc.Post("%s is online", contacts.DisplayName(nick))
+		default:
+			presence.Set(nick, false)
+			st := lastSeenStr(presence.Get(nick))
+			if st == "" {
+				st = "offline"
+			}
+			cc.Post("%s is %s", contacts.DisplayName(nick), st)
+		}
+	}()
+}
+
+func cmdClear(cc *cmdContext, _ string) {
+	cc.chat.Messages = nil
+	cc.chat.HasOlder = true // so history may be loaded back
+}
+
+func cmdOpen(cc *cmdContext, nick string) {
+	if !isSafeName(nick) || strings.HasPrefix(nick, "_") {
+		cc.Reply("Usage: %sopen <nick>", cmdPrefix)
+		return
+	}
+	if nick == conf.Name {
+		cc.Reply("You can't chat with yourself")
+		return
+	}
+	if GetByPN(store.Chats, nick).PeerName != "" {
+		cc.ui.ChatList.Selected = nick
+		return
+	}
+	go func() {
+		if err := openChat(cc.ui, nick); err != nil {
+			cc.Post("%v", err)
+		}
+	}()
+}
+
+// openChat selects chat with nick, creating it if user exists;
+// it asks server, so it mustn't be called on UI goroutine
+func openChat(ui *UI, nick string) error {
+	is, exs, err := isOnline(nick)
+	if err != nil {
+		errl.Println(err)
+		return fmt.Errorf("Error asking server about %s", nick)
+	}
+	if !exs {
+		return fmt.Errorf("User %s doesn't exist", nick)
+	}
+	presence.Set(nick, is)
+	store.Do(func() {
+		if GetByPN(store.Chats, nick).PeerName == "" {
+			store.Chats = append(store.Chats, newChat(nick))
+		}
+		ui.ChatList.Selected = nick
+	})
+	return nil
+}
+
+func cmdMe(cc *cmdContext, action string) {
+	if action == "" {
+		cc.Reply("Usage: %sme <action>", cmdPrefix)
+		return
+	}
+	if len([]rune(action))+len(conf.Name)+3 > cc.chat.MaxTextLen() {
+		cc.Reply("Message is too long")
+		return
+	}
+	cc.chat.SendText("* "+conf.Name+" "+action, "", func(err error) {
+		if err != nil {
+			cc.Post("Error sending your message :(")
+		}
+	})
+}
+
+func cmdExport(cc *cmdContext, _ string) {
+	peer, title := cc.chat.PeerName, cc.chat.Title()
+	go func() {
+		path, err := dialog.File().Filter("Text file", "txt").Title("Export chat").Save()
+		if err != nil {
+			if err != dialog.ErrCancelled {
+				errl.Println(err)
+			}
+			return
+		}
+		msgs, err := history.Load(peer)
+		if err != nil {
+			errl.Println(err)
+			cc.Post("Error reading history")
+			return
+		}
+		var sb strings.Builder
+		for _, g := range msgs {
+			fmt.Fprintf(&sb, "[%s] %s: %s\n", g.Time.Format("2006-01-02 15:04:05"), g.From, g.Text)
+		}
+		if err := ioutil.WriteFile(path, []byte(sb.String()), 0600); err != nil {
+			errl.Println(err)
+			cc.Post("Error writing %s", path)
+			return
+		}
+		cc.Post("Chat with %s (%d messages) is saved to %s", title, len(msgs), path)
+	}()
+}
+
+func cmdBlock(cc *cmdContext, _ string) {
+	if cc.chat.Group != nil {
+		cc.Reply("Groups can't be blocked; leave it instead")
+		return
+	}
+	cc.ca.toggleBlock()
+	if isBlocked(cc.chat.PeerName) {
+		cc.Reply("%s is blocked", cc.chat.PeerName)
+	} else {
+		cc.Reply("%s is not blocked", cc.chat.PeerName)
+	}
+}
+
+// layoutCompletion layouts list of commands matching input;
+// clicked command is put to input
+func (ca *ChatActivity) layoutCompletion(gtx C, th T, txt string) D {
+	if !strings.HasPrefix(txt, cmdPrefix) || strings.ContainsAny(txt, " \t\n") {
+		return D{}
+	}
+	matches := matchCommands(strings.TrimPrefix(txt, cmdPrefix))
+	if len(matches) == 0 || (len(matches) == 1 && cmdPrefix+matches[0].Name == txt) {
+		return D{}
+	}
+	if ca.cmdBtns == nil {
+		ca.cmdBtns = make(map[string]*widget.Clickable)
+	}
+	children := make([]layout.FlexChild, 0, len(matches))
+	for _, c := range matches {
+		btn, ok := ca.cmdBtns[c.Name]
+		if !ok {
+			btn = new(widget.Clickable)
+			ca.cmdBtns[c.Name] = btn
+		}
+		if btn.Clicked() {
+			ca.Input.Editor.SetText(cmdPrefix + c.Name + " ")
+			n := len([]rune(ca.Input.Editor.Text()))
+			ca.Input.Editor.SetCaret(n, n)
+			ca.Input.Editor.Focus()
+		}
+		line := cmdPrefix + c.Name
+		if c.Args != "" {
+			line += " " + c.Args
+		}
+		line += " — " + c.Help
+		children = append(children, layout.Rigid(func(gtx C) D {
+			return material.Clickable(gtx, btn, func(gtx C) D {
+				gtx.Constraints.Min.X = gtx.Constraints.Max.X
+				return layout.UniformInset(unit.Dp(3)).Layout(gtx, material.Body2(th, line).Layout)
+			})
+		}))
+	}
+	return layout.Inset{Bottom: unit.Dp(5)}.Layout(gtx, func(gtx C) D {
+		return widget.Border{
+			Color:        th.Fg,
+			Width:        unit.Dp(0.5),
+			CornerRadius: unit.Dp(3),
+		}.Layout(gtx, func(gtx C) D {
+			return layout.Flex{Axis: layout.Vertical}.Layout(gtx, children...)
+		})
+	})
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"gioui.org/x/pref/theme"
 	"github.com/BurntSushi/toml"
 	"github.com/sqweek/dialog"
@@ -13,6 +14,32 @@ var conf = struct {
 	Token      string   `toml:"token"`
 	IsDark     bool     `toml:"is_dark"`
 	ServerURLs []string `toml:"server_urls"`
+	// MaxMessageLen is max length of message in runes
+	MaxMessageLen int `toml:"max_message_len"`
+	// RawText disables formatting of messages
+	RawText bool `toml:"raw_text"`
+	// BrowserCmd is command to open links with, url is added as last argument.
+	// If empty, system's default browser is used
+	BrowserCmd string `toml:"browser_cmd"`
+	// NoTypingNotifs disables sending of typing notifications
+	NoTypingNotifs bool `toml:"no_typing_notifs"`
+	// NoReadReceipts disables sending of read receipts (delivery is told anyway)
+	NoReadReceipts bool `toml:"no_read_receipts"`
+	// DownloadDir is where got files are saved
+	DownloadDir string `toml:"download_dir"`
+	// MaxFileSize is max size of sent and got files in bytes
+	MaxFileSize int64 `toml:"max_file_size"`
+	// ImagesOnlyFromKnown disables auto loading of images from peers user never wrote to
+	ImagesOnlyFromKnown bool `toml:"images_only_from_known"`
+	// Blocked are nicks whose messages aren't shown; they are kept in history until nick is unblocked
+	Blocked []string `toml:"blocked"`
+	// Webhooks get got messages as JSON; replies from them are sent back
+	Webhooks []Webhook `toml:"webhook"`
+	// IRCAddr is loopback address of IRC gateway, like "127.0.0.1:6667";
+	// if empty, gateway is off. IRC client logs in with IRCPass as password
+	IRCAddr string `toml:"irc_addr"`
+	// IRCPass is password of IRC gateway; gateway doesn't start without it
+	IRCPass string `toml:"irc_pass"`
 }{}
 
 func initConfig() {
@@ -48,19 +75,45 @@ func initConfig() {
 		conf.Name, conf.Token = "", ""
 		saveConf() // no checking error because yes))))
 	}
+	if setDefaults() {
+		saveConf() // the same as in last if
+	}
+	store.SetMaxMessageLen(conf.MaxMessageLen)
+}
+
+// setDefaults fills fields which aren't set in config; returns true if any was filled
+func setDefaults() bool {
+	var changed bool
 	if len(conf.ServerURLs) == 0 {
 		conf.ServerURLs = []string{
 			// while i haven't deployed server, there will be only localhost
 			"localhost",
 		}
-		saveConf() // the same as in last if
+		changed = true
+	}
+	if conf.MaxMessageLen <= 0 {
+		conf.MaxMessageLen = 2048
+		changed = true
+	}
+	if conf.DownloadDir == "" {
+		conf.DownloadDir = "downloads"
+		changed = true
+	}
+	if conf.MaxFileSize <= 0 {
+		conf.MaxFileSize = 10 << 20
+		changed = true
 	}
+	return changed
 }
 
 func saveConf() error {
-	f, err := os.Create("config.toml")
+	var (
+		buf bytes.Buffer
+		err error
+	)
+	store.ReadConf(func() { err = toml.NewEncoder(&buf).Encode(conf) })
 	if err != nil {
 		return err
 	}
-	return toml.NewEncoder(f).Encode(conf)
+	return ioutil.WriteFile("config.toml", buf.Bytes(), 0666)
 }
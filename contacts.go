@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"github.com/BurntSushi/toml"
+	"github.com/sqweek/dialog"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// contactsFile is stored in user's history directory
+const contactsFile = "contacts.toml"
+
+var errBadColor = errors.New("color must be like #ff8800")
+
+// Contact is saved peer
+type Contact struct {
+	Nick      string `toml:"nick"`
+	Alias     string `toml:"alias,omitempty"`
+	Note      string `toml:"note,omitempty"`
+	Favourite bool   `toml:"favourite,omitempty"`
+	// Color is color of nick in #rrggbb format
+	Color string `toml:"color,omitempty"`
+}
+
+// contactsDoc is how contacts are stored in file
+type contactsDoc struct {
+	Contacts []Contact `toml:"contact"`
+}
+
+// Contacts is contact book of current user
+type Contacts struct {
+	mu       sync.Mutex
+	m        map[string]Contact
+	loadedOf string // name of user whose contacts are loaded
+}
+
+var contacts = &Contacts{m: make(map[string]Contact)}
+
+func contactsPath() string {
+	return filepath.Join(historyDir, store.Name(), contactsFile)
+}
+
+// parseColor parses #rrggbb
+func parseColor(s string) (color.NRGBA, error) {
+	var r, g, b uint8
+	if len(s) != 7 || s[0] != '#' {
+		return color.NRGBA{}, errBadColor
+	}
+	if _, err := fmt.Sscanf(s[1:], "%02x%02x%02x", &r, &g, &b); err != nil {
+		return color.NRGBA{}, errBadColor
+	}
+	return color.NRGBA{R: r, G: g, B: b, A: 255}, nil
+}
+
+// readContacts reads contacts from toml file
+func readContacts(path string) ([]Contact, error) {
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc contactsDoc
+	if err := toml.Unmarshal(dat, &doc); err != nil {
+		return nil, err
+	}
+	return doc.Contacts, nil
+}
+
+// load reads contacts if user changed; must be called with locked mu
+func (cs *Contacts) load() {
+	if cs.loadedOf == store.Name() {
+		return
+	}
+	cs.loadedOf, cs.m = store.Name(), make(map[string]Contact)
+	if !isSafeName(store.Name()) {
+		return
+	}
+	list, err := readContacts(contactsPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			errl.Println(err)
+		}
+		return
+	}
+	for _, c := range list {
+		if isSafeName(c.Nick) {
+			cs.m[c.Nick] = c
+		}
+	}
+}
+
+// list returns contacts, favourite ones first; must be called with locked mu
+func (cs *Contacts) list() []Contact {
+	list := make([]Contact, 0, len(cs.m))
+	for _, c := range cs.m {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Favourite != list[j].Favourite {
+			return list[i].Favourite
+		}
+		return strings.ToLower(list[i].Nick) < strings.ToLower(list[j].Nick)
+	})
+	return list
+}
+
+// write writes contacts to file; must be called with locked mu
+func (cs *Contacts) write(path string) error {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(contactsDoc{Contacts: cs.list()}); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0600)
+}
+
+// save writes contacts of current user; must be called with locked mu
+func (cs *Contacts) save() error {
+	if !isSafeName(store.Name()) {
+		return errBadPeerName
+	}
+	return cs.write(contactsPath())
+}
+
+// All returns all contacts, favourite ones first
+func (cs *Contacts) All() []Contact {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.load()
+	return cs.list()
+}
+
+// Get returns contact by nick
+func (cs *Contacts) Get(nick string) (Contact, bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.load()
+	c, ok := cs.m[nick]
+	return c, ok
+}
+
+// DisplayName returns alias of peer or it's nick if it has no alias
+func (cs *Contacts) DisplayName(nick string) string {
+	if c, ok := cs.Get(nick); ok && c.Alias != "" {
+		return c.Alias
+	}
+	return nick
+}
+
+// ColorOf returns color of peer if it's set
+func (cs *Contacts) ColorOf(nick string) (color.NRGBA, bool) {
+	c, ok := cs.Get(nick)
+	if !ok || c.Color == "" {
+		return color.NRGBA{}, false
+	}
+	col, err := parseColor(c.Color)
+	return col, err == nil
+}
+
+// Put adds or updates contact
+func (cs *Contacts) Put(c Contact) error {
+	if !isSafeName(c.Nick) {
+		return errBadPeerName
+	}
+	if c.Color != "" {
+		if _, err := parseColor(c.Color); err != nil {
+			return err
+		}
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.load()
+	cs.m[c.Nick] = c
+	return cs.save()
+}
+
+// Remove removes contact
+func (cs *Contacts) Remove(nick string) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.load()
+	delete(cs.m, nick)
+	return cs.save()
+}
+
+// Export writes contacts to file
+func (cs *Contacts) Export(path string) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.load()
+	return cs.write(path)
+}
+
+// Import adds contacts from file; existing contacts are replaced. Returns nicks of imported contacts
+func (cs *Contacts) Import(path string) ([]string, error) {
+	list, err := readContacts(path)
+	if err != nil {
+		return nil, err
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.load()
+	var nicks []string
+	for _, c := range list {
+		if !isSafeName(c.Nick) || c.Nick == store.Name() {
+			continue
+		}
+		if c.Color != "" {
+			if _, err := parseColor(c.Color); err != nil {
+				c.Color = ""
+			}
+		}
+		cs.m[c.Nick] = c
+		nicks = append(nicks, c.Nick)
+	}
+	return nicks, cs.save()
+}
+
+// contactChats creates chats for all contacts
+func contactChats() []*Chat {
+	chats := make([]*Chat, 0)
+	for _, c := range contacts.All() {
+		chats = append(chats, newChat(c.Nick))
+	}
+	return chats
+}
+
+// ContactEditor is form for editing of contact shown instead of messages
+type ContactEditor struct {
+	Open      bool
+	Nick      string
+	EditBtn   material.ButtonStyle
+	Alias     material.EditorStyle
+	Note      material.EditorStyle
+	Color     material.EditorStyle
+	Favourite material.SwitchStyle
+	SaveBtn   material.ButtonStyle
+	RemoveBtn material.ButtonStyle
+	CloseBtn  material.ButtonStyle
+}
+
+// newContactEditor is constructor for ContactEditor
+func newContactEditor(th T) *ContactEditor {
+	return &ContactEditor{
+		EditBtn:   material.Button(th, new(widget.Clickable), "Contact"),
+		Alias:     material.Editor(th, &widget.Editor{SingleLine: true}, "Alias"),
+		Note:      material.Editor(th, new(widget.Editor), "Note"),
+		Color:     material.Editor(th, &widget.Editor{SingleLine: true}, "#rrggbb"),
+		Favourite: material.Switch(th, new(widget.Bool), "Favourite"),
+		SaveBtn:   material.Button(th, new(widget.Clickable), "Save"),
+		RemoveBtn: material.Button(th, new(widget.Clickable), "Remove from contacts"),
+		CloseBtn:  material.Button(th, new(widget.Clickable), "Close"),
+	}
+}
+
+// Show opens form with contact's data
+func (ce *ContactEditor) Show(nick string) {
+	c, _ := contacts.Get(nick)
+	ce.Open, ce.Nick = true, nick
+	ce.Alias.Editor.SetText(c.Alias)
+	ce.Note.Editor.SetText(c.Note)
+	ce.Color.Editor.SetText(c.Color)
+	ce.Favourite.Switch.Value = c.Favourite
+}
+
+// Layout layouts form
+func (ce *ContactEditor) Layout(gtx C, th T) D {
+	if ce.CloseBtn.Button.Clicked() {
+		ce.Open = false
+		return D{}
+	}
+	if ce.SaveBtn.Button.Clicked() {
+		err := contacts.Put(Contact{
+			Nick:      ce.Nick,
+			Alias:     strings.TrimSpace(ce.Alias.Editor.Text()),
+			Note:      strings.TrimSpace(ce.Note.Editor.Text()),
+			Favourite: ce.Favourite.Switch.Value,
+			Color:     strings.TrimSpace(ce.Color.Editor.Text()),
+		})
+		if err == errBadColor {
+			dialog.Message("Color must be like #ff8800").Title("0_0").Info()
+		} else if err != nil {
+			errl.Println(err)
+			dialog.Message("Error saving contact").Title("Error!!1").Error()
+		} else {
+			ce.Open = false
+			return D{}
+		}
+	}
+	_, saved := contacts.Get(ce.Nick)
+	if ce.RemoveBtn.Button.Clicked() && saved {
+		if dialog.Message("Remove %s from contacts?", ce.Nick).Title("Remove").YesNo() {
+			if err := contacts.Remove(ce.Nick); err != nil {
+				errl.Println(err)
+				dialog.Message("Error removing contact").Title("Error!!1").Error()
+			}
+			ce.Open = false
+			return D{}
+		}
+	}
+	field := func(e material.EditorStyle) layout.FlexChild {
+		return layout.Rigid(func(gtx C) D {
+			return widget.Border{
+				CornerRadius: unit.Dp(5),
+				Color:        th.Fg,
+				Width:        unit.Dp(0.5),
+			}.Layout(gtx, func(gtx C) D {
+				return layout.UniformInset(unit.Dp(4)).Layout(gtx, e.Layout)
+			})
+		})
+	}
+	return layout.UniformInset(unit.Dp(15)).Layout(gtx, func(gtx C) D {
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+			layout.Rigid(material.Label(th, unit.Dp(20), "Contact "+ce.Nick).Layout),
+			hspacer,
+			layout.Rigid(material.Body2(th, "Alias:").Layout),
+			field(ce.Alias),
+			hspacer,
+			layout.Rigid(material.Body2(th, "Note:").Layout),
+			field(ce.Note),
+			hspacer,
+			layout.Rigid(material.Body2(th, "Color of nick:").Layout),
+			field(ce.Color),
+			hspacer,
+			layout.Rigid(func(gtx C) D {
+				return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+					layout.Rigid(material.Body2(th, "Favourite:\t").Layout),
+					layout.Rigid(ce.Favourite.Layout),
+				)
+			}),
+			hspacer,
+			layout.Rigid(func(gtx C) D {
+				return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+					layout.Rigid(ce.SaveBtn.Layout),
+					wspacer,
+					layout.Rigid(ce.CloseBtn.Layout),
+					wspacer,
+					layout.Rigid(func(gtx C) D {
+						if !saved {
+							return D{}
+						}
+						return ce.RemoveBtn.Layout(gtx)
+					}),
+				)
+			}),
+		)
+	})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+const ctlUsage = `Usage: overmsg-client ctl <command>
+
+Commands:
+  send <nick> <text...>  send message
+  chats                  list chats as JSON
+  unread [nick]          print count of unread messages
+  subscribe              print got messages as JSON lines`
+
+// isCtl tells whether binary is started as ctl tool; then GUI, config and connection aren't used
+func isCtl() bool {
+	return len(os.Args) > 1 && os.Args[1] == "ctl"
+}
+
+// ctlMain talks to running client and returns exit code
+func ctlMain(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, ctlUsage)
+		return 2
+	}
+	req := ipcRequest{Cmd: args[0]}
+	switch {
+	case req.Cmd == "send" && len(args) >= 3:
+		req.To, req.Text = args[1], strings.Join(args[2:], " ")
+	case req.Cmd == "unread" && len(args) <= 2:
+		if len(args) == 2 {
+			req.To = args[1]
+		}
+	case (req.Cmd == "chats" || req.Cmd == "subscribe") && len(args) == 1:
+	default:
+		fmt.Fprintln(os.Stderr, ctlUsage)
+		return 2
+	}
+	conn, err := net.Dial("unix", ipcSocket)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "client isn't running:", err)
+		return 1
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	in := bufio.NewScanner(conn)
+	in.Buffer(nil, 1<<20)
+	if !in.Scan() {
+		fmt.Fprintln(os.Stderr, "no answer from client:", in.Err())
+		return 1
+	}
+	var resp ipcResponse
+	if err := json.Unmarshal(in.Bytes(), &resp); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if !resp.OK {
+		fmt.Fprintln(os.Stderr, "error:", resp.Error)
+		return 1
+	}
+	switch req.Cmd {
+	case "send":
+		fmt.Println(resp.ID)
+	case "chats":
+		for _, c := range resp.Chats {
+			dat, _ := json.Marshal(c)
+			fmt.Println(string(dat))
+		}
+	case "unread":
+		if resp.Unread == nil {
+			fmt.Fprintln(os.Stderr, "client didn't tell number of unread messages")
+			return 1
+		}
+		fmt.Println(*resp.Unread)
+	case "subscribe":
+		for in.Scan() {
+			fmt.Println(in.Text())
+		}
+		if err := in.Err(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	return 0
+}
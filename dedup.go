@@ -0,0 +1,45 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// dedupTTL is how long ids of got messages are remembered
+const dedupTTL = 15 * time.Minute
+
+// DedupWindow remembers ids of recently got messages, so frames which
+// server resent (e.g. after reconnection) are not shown twice
+type DedupWindow struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	seen   map[string]time.Time
+	pruned time.Time
+}
+
+// NewDedupWindow is constructor for DedupWindow
+func NewDedupWindow(ttl time.Duration) *DedupWindow {
+	return &DedupWindow{ttl: ttl, seen: make(map[string]time.Time)}
+}
+
+var dedup = NewDedupWindow(dedupTTL)
+
+// Seen returns true if id was seen during ttl and remembers it
+func (d *DedupWindow) Seen(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	if now.Sub(d.pruned) > d.ttl/10 {
+		for k, t := range d.seen {
+			if now.Sub(t) > d.ttl {
+				delete(d.seen, k)
+			}
+		}
+		d.pruned = now
+	}
+	if t, ok := d.seen[id]; ok && now.Sub(t) <= d.ttl {
+		return true
+	}
+	d.seen[id] = now
+	return false
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"gioui.org/layout"
+	"gioui.org/widget/material"
+	"sync"
+	"time"
+)
+
+// recvQueueSize is how many got messages may wait for UI; when queue is full,
+// reader of connection waits, so server (and peers) are slowed down
+const recvQueueSize = 256
+
+// Diagnostics collects stats of receive pipeline
+type Diagnostics struct {
+	mu       sync.Mutex
+	received int
+	last     time.Duration
+	avg      time.Duration // exponential moving average
+	max      time.Duration
+}
+
+var diag = new(Diagnostics)
+
+// Record adds latency of message from reading it from connection to showing it
+func (d *Diagnostics) Record(lat time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.received++
+	d.last = lat
+	if d.received == 1 {
+		d.avg = lat
+	} else {
+		d.avg += (lat - d.avg) / 8
+	}
+	if lat > d.max {
+		d.max = lat
+	}
+}
+
+// Layout layouts stats
+func (d *Diagnostics) Layout(gtx C, th T) D {
+	d.mu.Lock()
+	received, last, avg, max := d.received, d.last, d.avg, d.max
+	d.mu.Unlock()
+	conn := "online"
+	if !store.Connected() {
+		conn = "reconnecting"
+	}
+	lines := []string{
+		"Connection: " + conn,
+		fmt.Sprintf("Messages received: %d", received),
+		fmt.Sprintf("Queue: %d/%d", len(messCh), cap(messCh)),
+		fmt.Sprintf("Latency: last %v, average %v, max %v", last.Round(time.Microsecond),
+			avg.Round(time.Microsecond), max.Round(time.Microsecond)),
+	}
+	children := make([]layout.FlexChild, 0, len(lines))
+	for _, l := range lines {
+		children = append(children, layout.Rigid(material.Body2(th, l).Layout))
+	}
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx, children...)
+}
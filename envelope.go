@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/dikey0ficial/overmsg-client/internal/proto"
+)
+
+// content types of messages
+const (
+	ctText = proto.TypeText
+)
+
+// isControlType returns true if messages of this type are not shown in chat
+func isControlType(typ string) bool {
+	switch typ {
+	case ctTyping, ctReceipt, ctFileAnswer, ctFileChunk, ctFileResend:
+		return true
+	}
+	return false
+}
+
+// envelope is metadata of message; it's format is shared with bot package
+type envelope = proto.Envelope
+
+// newEnvelope returns envelope of new message with new id
+func newEnvelope(typ string) envelope {
+	return envelope{
+		V:    proto.EnvelopeVersion,
+		ID:   proto.NewMessageID(),
+		Type: typ,
+	}
+}
+
+// envelopeLen returns how many runes envelope adds to text
+func envelopeLen(env envelope) int {
+	raw, err := proto.Encode("", env)
+	if err != nil {
+		return 0
+	}
+	return len([]rune(raw))
+}
+
+// maxTextLen returns max length of text of message to peer, so
+// text with envelope fits in server's limit
+func maxTextLen() int {
+	env := newEnvelope(ctText)
+	env.ReplyTo = env.ID // reply makes envelope longest
+	return store.MaxMessageLen() - envelopeLen(env)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"github.com/dikey0ficial/overmsg-client/internal/proto"
+	"github.com/sqweek/dialog"
+	"sync"
+	"time"
+)
+
+const (
+	// ctSystem is type of local messages; they are never sent nor stored
+	ctSystem = "system"
+	// floodRate is how many messages per second one peer may send
+	floodRate = 3
+	// floodBurst is how many messages peer may send at once
+	floodBurst = 15
+	// maxFPS is max rate of redraws caused by got messages
+	maxFPS = 30
+	// maxChatMessages is how many messages of chat are kept in memory;
+	// older ones are loaded from history when user asks
+	maxChatMessages = 500
+	// historyPage is how many older messages are loaded at once
+	historyPage = 100
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// suppressed is count of messages dropped since last allowed one
+	suppressed int
+	// markerID is id of message which tells how many messages were suppressed
+	markerID string
+}
+
+// FloodLimiter limits rate of messages from every peer (token bucket)
+type FloodLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+var flood = &FloodLimiter{buckets: make(map[string]*bucket)}
+
+// Allow returns true if message from peer must be shown. If it mustn't,
+// it returns id and text of marker which must be added or updated
+func (fl *FloodLimiter) Allow(peer string) (ok bool, markerID, marker string) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	now := time.Now()
+	b, ok := fl.buckets[peer]
+	if !ok {
+		b = &bucket{tokens: floodBurst, last: now}
+		fl.buckets[peer] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * floodRate
+	if b.tokens > floodBurst {
+		b.tokens = floodBurst
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		b.suppressed, b.markerID = 0, ""
+		return true, "", ""
+	}
+	if b.markerID == "" {
+		b.markerID = proto.NewMessageID()
+	}
+	b.suppressed++
+	return false, b.markerID, fmt.Sprintf("%d more messages suppressed (click to show them)", b.suppressed)
+}
+
+// throttle returns function which calls f at most fps times per second;
+// calls between are collapsed into one
+func throttle(f func(), fps int) func() {
+	var (
+		mu      sync.Mutex
+		pending bool
+		last    time.Time
+		period  = time.Second / time.Duration(fps)
+	)
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if pending {
+			return
+		}
+		wait := period - time.Since(last)
+		if wait <= 0 {
+			last = time.Now()
+			f()
+			return
+		}
+		pending = true
+		time.AfterFunc(wait, func() {
+			mu.Lock()
+			pending, last = false, time.Now()
+			mu.Unlock()
+			f()
+		})
+	}
+}
+
+// SetMarker adds local message which tells how many messages were suppressed or updates it;
+// hidden is id of suppressed message
+func (c *Chat) SetMarker(id, txt, hidden string) {
+	if c.suppressed == nil {
+		c.suppressed = make(map[string][]string)
+	}
+	c.suppressed[id] = append(c.suppressed[id], hidden)
+	for i := range c.Messages {
+		if c.Messages[i].ID == id {
+			c.Messages[i].Text = txt
+			return
+		}
+	}
+	c.AddMessage(GUIMessage{ID: id, Type: ctSystem, Text: txt, Time: time.Now()})
+}
+
+// ShowSuppressed replaces flood marker with messages it hides; they're loaded from history
+func (c *Chat) ShowSuppressed(markerID string) {
+	ids := c.suppressed[markerID]
+	if len(ids) == 0 {
+		return
+	}
+	delete(c.suppressed, markerID)
+	go func() {
+		history.Flush() // they may be not stored yet
+		msgs, err := history.LoadIDs(c.PeerName, ids)
+		if err != nil {
+			errl.Println(err)
+			dialog.Message("Error loading history").Title("Error!!1").Error()
+			return
+		}
+		store.Do(func() {
+			for i := range c.Messages {
+				if c.Messages[i].ID == markerID {
+					shown := make([]GUIMessage, 0, len(c.Messages)+len(msgs))
+					shown = append(shown, c.Messages[:i]...)
+					shown = append(shown, msgs...)
+					c.Messages = append(shown, c.Messages[i+1:]...)
+					c.trim()
+					return
+				}
+			}
+		})
+	}()
+}
+
+// trim drops oldest messages if chat has too many of them
+func (c *Chat) trim() {
+	if len(c.Messages) <= maxChatMessages {
+		return
+	}
+	drop := len(c.Messages) - maxChatMessages
+	c.Messages = append([]GUIMessage(nil), c.Messages[drop:]...)
+	c.HasOlder = true
+}
+
+// LoadOlder loads page of messages older than the first shown one from history in background;
+// loaded is called on UI goroutine with number of messages put before shown ones
+func (c *Chat) LoadOlder(loaded func(n int)) {
+	if c.loadingOlder {
+		return
+	}
+	c.loadingOlder = true
+	var first GUIMessage
+	for _, g := range c.Messages {
+		if g.Type != ctSystem {
+			first = g
+			break
+		}
+	}
+	go func() {
+		history.Flush() // first message may be not stored yet
+		msgs, more, err := history.LoadPage(c.PeerName, first.ID, first.Time, historyPage)
+		if err != nil {
+			errl.Println(err)
+			store.Do(func() { c.loadingOlder = false })
+			dialog.Message("Error loading history").Title("Error!!1").Error()
+			return
+		}
+		markPending(c.PeerName, msgs)
+		store.Do(func() {
+			c.loadingOlder = false
+			have := make(map[string]bool, len(c.Messages))
+			for _, g := range c.Messages {
+				have[g.ID] = true
+			}
+			old := msgs[:0]
+			for _, g := range msgs {
+				if !have[g.ID] {
+					old = append(old, g)
+				}
+			}
+			c.Messages = append(old, c.Messages...)
+			c.HasOlder = more
+			loaded(len(old))
+		})
+	}()
+}
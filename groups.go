@@ -0,0 +1,487 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"github.com/sqweek/dialog"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// groupPrefix starts names of group chats; nicks can't contain it
+	groupPrefix = "+"
+	// groupsFile is stored in user's history directory
+	groupsFile = "groups.json"
+	// ctGroupNotice is type of messages which tell about changes of members
+	ctGroupNotice = "group_notice"
+	// actions of notices
+	groupCreate = "create"
+	groupAdd    = "add"
+	groupRemove = "remove"
+	groupLeave  = "leave"
+	groupRename = "rename"
+)
+
+var errNotMember = errors.New("you aren't member of this group")
+
+// Group is chat of several users. Server knows nothing about it: every
+// message is sent to every member with group in envelope
+type Group struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+// groupNotice is what was changed in group
+type groupNotice struct {
+	Action string   `json:"action"`
+	Who    []string `json:"who,omitempty"`
+}
+
+// Key returns name of group's chat
+func (g Group) Key() string {
+	return groupPrefix + g.ID
+}
+
+// HasMember returns true if nick is member of group
+func (g Group) HasMember(nick string) bool {
+	for _, m := range g.Members {
+		if m == nick {
+			return true
+		}
+	}
+	return false
+}
+
+// Others returns members except user
+func (g Group) Others() []string {
+	me := store.Name()
+	others := make([]string, 0, len(g.Members))
+	for _, m := range g.Members {
+		if m != me {
+			others = append(others, m)
+		}
+	}
+	return others
+}
+
+// Groups keeps groups of current user
+type Groups struct {
+	mu       sync.Mutex
+	m        map[string]Group
+	loadedOf string // name of user whose groups are loaded
+}
+
+var groups = &Groups{m: make(map[string]Group)}
+
+func groupsPath() string {
+	return filepath.Join(historyDir, store.Name(), groupsFile)
+}
+
+// load reads groups if user changed; must be called with locked mu
+func (gs *Groups) load() {
+	if gs.loadedOf == store.Name() {
+		return
+	}
+	gs.loadedOf, gs.m = store.Name(), make(map[string]Group)
+	if !isSafeName(store.Name()) {
+		return
+	}
+	dat, err := ioutil.ReadFile(groupsPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			errl.Println(err)
+		}
+		return
+	}
+	var list []Group
+	if err := json.Unmarshal(dat, &list); err != nil {
+		errl.Println(err)
+		return
+	}
+	for _, g := range list {
+		if isSafeName(g.ID) {
+			gs.m[g.ID] = g
+		}
+	}
+}
+
+// save writes groups; must be called with locked mu
+func (gs *Groups) save() error {
+	if !isSafeName(store.Name()) {
+		return errBadPeerName
+	}
+	list := make([]Group, 0, len(gs.m))
+	for _, g := range gs.m {
+		list = append(list, g)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	dat, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(groupsPath()), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(groupsPath(), dat, 0600)
+}
+
+// Get returns group by id
+func (gs *Groups) Get(id string) (Group, bool) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.load()
+	g, ok := gs.m[id]
+	return g, ok
+}
+
+// Put adds or updates group
+func (gs *Groups) Put(g Group) error {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.load()
+	gs.m[g.ID] = g
+	return gs.save()
+}
+
+// All returns all groups
+func (gs *Groups) All() []Group {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.load()
+	list := make([]Group, 0, len(gs.m))
+	for _, g := range gs.m {
+		list = append(list, g)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// newGroupChat creates chat of group
+func newGroupChat(g Group) *Chat {
+	c := newChat(g.Key())
+	c.Group = &g
+	return c
+}
+
+// savedChats creates chats for all contacts, groups and peers which have history;
+// blocked peers which aren't contacts are skipped
+func savedChats() []*Chat {
+	chats := contactChats()
+	for _, g := range groups.All() {
+		chats = append(chats, newGroupChat(g))
+	}
+	peers, err := history.Peers()
+	if err != nil {
+		errl.Println(err)
+	}
+	for _, peer := range peers {
+		if GetByPN(chats, peer).PeerName == "" && !isBlocked(peer) {
+			chats = append(chats, newChat(peer))
+		}
+	}
+	return chats
+}
+
+// noticeText returns text shown in chat for notice
+func noticeText(by string, g Group, n groupNotice) string {
+	who := strings.Join(n.Who, ", ")
+	switch n.Action {
+	case groupCreate:
+		return by + " created group " + g.Name
+	case groupAdd:
+		return by + " added " + who
+	case groupRemove:
+		return by + " removed " + who
+	case groupLeave:
+		return by + " left group"
+	case groupRename:
+		return by + " renamed group to " + g.Name
+	}
+	return by + " changed group"
+}
+
+// sendToGroup sends message to every member of group; messages to
+// offline members are queued in outbox. Returns error of the first failed
+// sending, but sends to everyone anyway
+func sendToGroup(g Group, txt string, msg GUIMessage, env envelope, to []string) error {
+	if err := env.SetAttr("group", g); err != nil {
+		return err
+	}
+	var first error
+	for _, member := range to {
+		var err error
+		if presence.IsOffline(member) {
+			err = outbox.Queue(member, msg, env)
+		} else {
+			err = sendEnvelope(store.Token(), txt, member, env)
+		}
+		if err != nil {
+			errl.Println(err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// changeGroup applies change of group and tells every old and new member about it;
+// it's called on UI goroutine
+func changeGroup(c *Chat, g Group, n groupNotice) error {
+	if err := groups.Put(g); err != nil {
+		return err
+	}
+	to := g.Others()
+	if c.Group != nil {
+		for _, m := range c.Group.Others() {
+			if !g.HasMember(m) { // removed ones must know it too
+				to = append(to, m)
+			}
+		}
+	}
+	c.Group = &g
+	env := newEnvelope(ctGroupNotice)
+	if err := env.SetAttr("notice", n); err != nil {
+		return err
+	}
+	msg := GUIMessage{
+		ID:   env.ID,
+		From: store.Name(),
+		Text: noticeText(store.Name(), g, n),
+		Type: ctGroupNotice,
+		Time: time.Now(),
+	}
+	c.AddMessage(msg)
+	history.Queue(c.PeerName, msg)
+	go func() {
+		if err := sendToGroup(g, "", msg, env, to); err != nil {
+			dialog.Message("Not every member got notice about changes of group").Title("Error!!1").Error()
+		}
+	}()
+	return nil
+}
+
+// handleGroupMessage routes got message to group chat; it's called on UI goroutine
+func handleGroupMessage(from string, msg GUIMessage, env *envelope, info Group) {
+	if info.ID == "" || !isSafeName(info.ID) {
+		return
+	}
+	old, known := groups.Get(info.ID)
+	if known && !old.HasMember(from) {
+		return // only members may write to group
+	}
+	if !known && (!info.HasMember(from) || !info.HasMember(store.Name())) {
+		return
+	}
+	c := GetByPN(store.Chats, info.Key())
+	if c.PeerName == "" {
+		if known {
+			c = newGroupChat(old)
+		} else {
+			c = newGroupChat(info)
+		}
+		store.Chats = append(store.Chats, c)
+	}
+	switch {
+	case msg.Type == ctGroupNotice:
+		var n groupNotice
+		if !env.Attr("notice", &n) {
+			return
+		}
+		if err := groups.Put(info); err != nil {
+			errl.Println(err)
+		}
+		c.Group = &info
+		msg.Text = noticeText(from, info, n)
+	case isControlType(msg.Type) || msg.Type == ctFileOffer:
+		return // typing, receipts and files aren't supported in groups
+	case !known:
+		if err := groups.Put(info); err != nil {
+			errl.Println(err)
+		}
+		c.Group = &info
+	}
+	if ok, markerID, marker := flood.Allow(from); !ok {
+		history.Queue(c.PeerName, msg)
+		c.SetMarker(markerID, marker, msg.ID)
+		return
+	}
+	if c.AddMessage(msg) {
+		history.Queue(c.PeerName, msg)
+		ipc.Publish(msg, c.PeerName)
+		webhooks.Dispatch(msg, c.PeerName)
+	}
+}
+
+// GroupEditor is form for changing of group shown instead of messages
+type GroupEditor struct {
+	Open      bool
+	ID        string
+	EditBtn   material.ButtonStyle
+	Name      material.EditorStyle
+	RenameBtn material.ButtonStyle
+	AddInput  material.EditorStyle
+	AddBtn    material.ButtonStyle
+	LeaveBtn  material.ButtonStyle
+	CloseBtn  material.ButtonStyle
+	removeBtn map[string]*widget.Clickable
+}
+
+// newGroupEditor is constructor for GroupEditor
+func newGroupEditor(th T) *GroupEditor {
+	return &GroupEditor{
+		EditBtn:   material.Button(th, new(widget.Clickable), "Group"),
+		Name:      material.Editor(th, &widget.Editor{SingleLine: true}, "Name of group"),
+		RenameBtn: material.Button(th, new(widget.Clickable), "Rename"),
+		AddInput:  material.Editor(th, &widget.Editor{SingleLine: true, Submit: true}, "Nick"),
+		AddBtn:    material.Button(th, new(widget.Clickable), "Add"),
+		LeaveBtn:  material.Button(th, new(widget.Clickable), "Leave group"),
+		CloseBtn:  material.Button(th, new(widget.Clickable), "Close"),
+		removeBtn: make(map[string]*widget.Clickable),
+	}
+}
+
+// Show opens form for group
+func (ge *GroupEditor) Show(g Group) {
+	ge.Open, ge.ID = true, g.ID
+	ge.Name.Editor.SetText(g.Name)
+	ge.AddInput.Editor.SetText("")
+}
+
+// change applies change and shows error if it's failed
+func (ge *GroupEditor) change(c *Chat, g Group, n groupNotice) {
+	if err := changeGroup(c, g, n); err != nil {
+		errl.Println(err)
+		dialog.Message("Error changing group").Title("Error!!1").Error()
+	}
+}
+
+// Layout layouts form for group of chat c
+func (ge *GroupEditor) Layout(gtx C, th T, c *Chat) D {
+	g := *c.Group
+	me := store.Name()
+	if ge.CloseBtn.Button.Clicked() {
+		ge.Open = false
+		return D{}
+	}
+	if !g.HasMember(me) {
+		return layout.UniformInset(unit.Dp(15)).Layout(gtx, func(gtx C) D {
+			return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+				layout.Rigid(material.Body2(th, errNotMember.Error()).Layout),
+				hspacer,
+				layout.Rigid(ge.CloseBtn.Layout),
+			)
+		})
+	}
+	if name := strings.TrimSpace(ge.Name.Editor.Text()); ge.RenameBtn.Button.Clicked() && name != "" && name != g.Name {
+		g.Name = name
+		ge.change(c, g, groupNotice{Action: groupRename})
+	}
+	if nick := strings.TrimSpace(ge.AddInput.Editor.Text()); (ge.AddBtn.Button.Clicked() || isSubmit(ge.AddInput)) && nick != "" {
+		if !isSafeName(nick) || g.HasMember(nick) {
+			dialog.Message("Bad nick or it's already member").Title("0_0").Info()
+		} else {
+			g.Members = append(append([]string(nil), g.Members...), nick)
+			ge.change(c, g, groupNotice{Action: groupAdd, Who: []string{nick}})
+			ge.AddInput.Editor.SetText("")
+		}
+	}
+	if ge.LeaveBtn.Button.Clicked() && dialog.Message("Leave group %s?", g.Name).Title("Leave").YesNo() {
+		ng := g
+		ng.Members = g.Others()
+		ge.change(c, ng, groupNotice{Action: groupLeave})
+		ge.Open = false
+		return D{}
+	}
+	members := make([]layout.FlexChild, 0, len(g.Members))
+	for _, m := range g.Members {
+		m := m
+		btn, ok := ge.removeBtn[m]
+		if !ok {
+			btn = new(widget.Clickable)
+			ge.removeBtn[m] = btn
+		}
+		if btn.Clicked() && m != me {
+			ng := g
+			ng.Members = nil
+			for _, o := range g.Members {
+				if o != m {
+					ng.Members = append(ng.Members, o)
+				}
+			}
+			ge.change(c, ng, groupNotice{Action: groupRemove, Who: []string{m}})
+		}
+		members = append(members, layout.Rigid(func(gtx C) D {
+			return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+				layout.Rigid(func(gtx C) D { return layoutPresenceDot(gtx, m) }),
+				layout.Rigid(layout.Spacer{Width: unit.Dp(5)}.Layout),
+				layout.Rigid(material.Body2(th, contacts.DisplayName(m)).Layout),
+				wspacer,
+				layout.Rigid(func(gtx C) D {
+					if m == me {
+						return D{}
+					}
+					return material.Button(th, btn, "Remove").Layout(gtx)
+				}),
+			)
+		}))
+	}
+	field := func(e material.EditorStyle) layout.Widget {
+		return func(gtx C) D {
+			gtx.Constraints.Max.X = gtx.Px(unit.Dp(250))
+			gtx.Constraints.Min.X = gtx.Constraints.Max.X
+			return widget.Border{
+				CornerRadius: unit.Dp(5),
+				Color:        th.Fg,
+				Width:        unit.Dp(0.5),
+			}.Layout(gtx, func(gtx C) D {
+				return layout.UniformInset(unit.Dp(4)).Layout(gtx, e.Layout)
+			})
+		}
+	}
+	return layout.UniformInset(unit.Dp(15)).Layout(gtx, func(gtx C) D {
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+			layout.Rigid(material.Label(th, unit.Dp(20), "Group "+g.Name).Layout),
+			hspacer,
+			layout.Rigid(func(gtx C) D {
+				return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+					layout.Rigid(field(ge.Name)),
+					wspacer,
+					layout.Rigid(ge.RenameBtn.Layout),
+				)
+			}),
+			hspacer,
+			layout.Rigid(material.Body1(th, "Members:").Layout),
+			layout.Rigid(func(gtx C) D {
+				return layout.Flex{Axis: layout.Vertical}.Layout(gtx, members...)
+			}),
+			hspacer,
+			layout.Rigid(func(gtx C) D {
+				return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+					layout.Rigid(field(ge.AddInput)),
+					wspacer,
+					layout.Rigid(ge.AddBtn.Layout),
+				)
+			}),
+			hspacer,
+			layout.Rigid(func(gtx C) D {
+				return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+					layout.Rigid(ge.CloseBtn.Layout),
+					wspacer,
+					layout.Rigid(ge.LeaveBtn.Layout),
+				)
+			}),
+		)
+	})
+}
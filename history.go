@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// historyDir is directory where histories of chats are stored
+const historyDir = "history"
+
+// storedMessage is how message is stored in history file
+type storedMessage struct {
+	ID      string    `json:"id"`
+	From    string    `json:"from"`
+	Text    string    `json:"text"`
+	Type    string    `json:"type,omitempty"`
+	ReplyTo string    `json:"reply_to,omitempty"`
+	Time    time.Time `json:"time"`
+	// Status of own message is changed by receipts
+	Status   msgStatus `json:"status,omitempty"`
+	ReadSent bool      `json:"read_sent,omitempty"`
+	// Update is true for lines which only change status of message stored before
+	Update bool `json:"update,omitempty"`
+}
+
+// merge applies status of update to message
+func (sm *storedMessage) merge(upd storedMessage) {
+	if upd.Status.rank() > sm.Status.rank() {
+		sm.Status = upd.Status
+	}
+	sm.ReadSent = sm.ReadSent || upd.ReadSent
+}
+
+// History stores messages of every chat in it's own file, one json per line
+type History struct {
+	mu  sync.Mutex
+	ids map[string]map[string]bool // history file -> ids of stored messages; file depends on account
+	// writes are messages which are stored in background by Queue
+	writes chan historyWrite
+	once   sync.Once
+}
+
+type historyWrite struct {
+	peer string
+	g    GUIMessage
+	done chan struct{} // if it's set, it's closed instead of storing
+}
+
+var history = &History{
+	ids:    make(map[string]map[string]bool),
+	writes: make(chan historyWrite, recvQueueSize),
+}
+
+var errBadPeerName = errors.New("bad peer name for history file")
+
+// isSafeName checks that name can be used as file name
+func isSafeName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, sym := range name {
+		if !strings.ContainsRune(allowedSymbols, sym) {
+			return false
+		}
+	}
+	return true
+}
+
+func historyPath(peer string) string {
+	return filepath.Join(historyDir, store.Name(), peer+".jsonl")
+}
+
+// load reads history file; must be called with locked mu
+func (h *History) load(peer string) ([]storedMessage, error) {
+	if !isSafeName(strings.TrimPrefix(peer, groupPrefix)) || !isSafeName(store.Name()) {
+		return nil, errBadPeerName
+	}
+	ids := make(map[string]bool)
+	h.ids[historyPath(peer)] = ids
+	f, err := os.Open(historyPath(peer))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var msgs []storedMessage
+	idx := make(map[string]int)
+	// updates may be written before message, e.g. receipt comes before message is stored
+	early := make(map[string]storedMessage)
+	in := bufio.NewScanner(f)
+	in.Buffer(nil, 1<<24)
+	for in.Scan() {
+		var sm storedMessage
+		if err := json.Unmarshal(in.Bytes(), &sm); err != nil {
+			errl.Println(err) // one broken line shouldn't break whole history
+			continue
+		}
+		if sm.Update {
+			if i, ok := idx[sm.ID]; ok {
+				msgs[i].merge(sm)
+			} else {
+				upd := early[sm.ID]
+				upd.merge(sm)
+				early[sm.ID] = upd
+			}
+			continue
+		}
+		if ids[sm.ID] {
+			continue
+		}
+		if upd, ok := early[sm.ID]; ok {
+			sm.merge(upd)
+			delete(early, sm.ID)
+		}
+		ids[sm.ID] = true
+		idx[sm.ID] = len(msgs)
+		msgs = append(msgs, sm)
+	}
+	return msgs, in.Err()
+}
+
+// toGUI converts stored messages to GUIMessages
+func toGUI(sms []storedMessage) []GUIMessage {
+	msgs := make([]GUIMessage, 0, len(sms))
+	for _, sm := range sms {
+		msgs = append(msgs, GUIMessage{
+			ID:       sm.ID,
+			From:     sm.From,
+			Text:     sm.Text,
+			Type:     sm.Type,
+			ReplyTo:  sm.ReplyTo,
+			Time:     sm.Time,
+			Status:   sm.Status,
+			ReadSent: sm.ReadSent,
+			Seen:     true,
+		})
+	}
+	return msgs
+}
+
+// Load returns all stored messages of chat
+func (h *History) Load(peer string) ([]GUIMessage, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sms, err := h.load(peer)
+	if err != nil {
+		return nil, err
+	}
+	return toGUI(sms), nil
+}
+
+// LoadIDs returns stored messages with given ids in order they were stored
+func (h *History) LoadIDs(peer string, ids []string) ([]GUIMessage, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sms, err := h.load(peer)
+	if err != nil {
+		return nil, err
+	}
+	want := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		want[id] = true
+	}
+	var found []storedMessage
+	for _, sm := range sms {
+		if want[sm.ID] {
+			found = append(found, sm)
+		}
+	}
+	return toGUI(found), nil
+}
+
+// LoadPage returns up to n messages stored before message with id before
+// (or the last ones if before is empty) and true if there are older ones.
+// If before isn't stored, messages older than beforeTime are returned
+func (h *History) LoadPage(peer, before string, beforeTime time.Time, n int) ([]GUIMessage, bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sms, err := h.load(peer)
+	if err != nil {
+		return nil, false, err
+	}
+	end := len(sms)
+	if before != "" {
+		end = -1
+		for i, sm := range sms {
+			if sm.ID == before {
+				end = i
+				break
+			}
+		}
+		if end == -1 { // e.g. it was deleted or it isn't stored yet
+			end = sort.Search(len(sms), func(i int) bool { return !sms[i].Time.Before(beforeTime) })
+		}
+	}
+	start := end - n
+	if start < 0 {
+		start = 0
+	}
+	return toGUI(sms[start:end]), start > 0, nil
+}
+
+// Append stores message; message which is already stored is skipped
+func (h *History) Append(peer string, g GUIMessage) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ids, ok := h.ids[historyPath(peer)]
+	if !ok {
+		if _, err := h.load(peer); err != nil {
+			return err
+		}
+		ids = h.ids[historyPath(peer)]
+	}
+	if ids[g.ID] {
+		return nil
+	}
+	st := g.Status
+	if st == msgSending || st == msgWaiting { // messages in outbox are marked by markPending when loaded
+		st = msgSent
+	}
+	dat, err := json.Marshal(storedMessage{
+		ID:      g.ID,
+		From:    g.From,
+		Text:    g.Text,
+		Type:    g.Type,
+		ReplyTo: g.ReplyTo,
+		Time:    g.Time,
+		Status:  st,
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(historyPath(peer)), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(historyPath(peer), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(dat, '\n')); err != nil {
+		return err
+	}
+	ids[g.ID] = true
+	return nil
+}
+
+// Queue stores message in background, so UI goroutine doesn't wait for disk.
+// Messages are stored in order they were queued
+func (h *History) Queue(peer string, g GUIMessage) {
+	h.write(historyWrite{peer: peer, g: g})
+}
+
+// Flush waits until queued messages are stored
+func (h *History) Flush() {
+	done := make(chan struct{})
+	h.write(historyWrite{done: done})
+	<-done
+}
+
+func (h *History) write(w historyWrite) {
+	h.once.Do(func() {
+		go func() {
+			for w := range h.writes {
+				if w.done != nil {
+					close(w.done)
+				} else if err := h.Append(w.peer, w.g); err != nil {
+					errl.Println(err)
+				}
+			}
+		}()
+	})
+	h.writes <- w
+}
+
+// Peers returns peers of user (not groups) whose chats have history
+func (h *History) Peers() ([]string, error) {
+	if !isSafeName(store.Name()) {
+		return nil, errBadPeerName
+	}
+	files, err := os.ReadDir(filepath.Join(historyDir, store.Name()))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var peers []string
+	for _, f := range files {
+		peer := strings.TrimSuffix(f.Name(), ".jsonl")
+		if f.IsDir() || peer == f.Name() || !isSafeName(peer) || peer == store.Name() {
+			continue
+		}
+		peers = append(peers, peer)
+	}
+	return peers, nil
+}
+
+// Mark stores status of messages and whether read receipts of them were sent
+func (h *History) Mark(peer string, ids []string, st msgStatus, readSent bool) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !isSafeName(strings.TrimPrefix(peer, groupPrefix)) || !isSafeName(store.Name()) {
+		return errBadPeerName
+	}
+	if err := os.MkdirAll(filepath.Dir(historyPath(peer)), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(historyPath(peer), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	for _, id := range ids {
+		if err := enc.Encode(storedMessage{ID: id, Status: st, ReadSent: readSent, Update: true}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes message from history
+func (h *History) Delete(peer, id string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sms, err := h.load(peer)
+	if err != nil {
+		return err
+	}
+	if !h.ids[historyPath(peer)][id] {
+		return nil
+	}
+	tmp := historyPath(peer) + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, sm := range sms {
+		if sm.ID == id {
+			continue
+		}
+		if err := enc.Encode(sm); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, historyPath(peer)); err != nil {
+		return err
+	}
+	delete(h.ids[historyPath(peer)], id)
+	return nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"gioui.org/layout"
+	"gioui.org/op/paint"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"image"
+	_ "image/gif" // registers decoders
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// thumbSize is max side of thumbnail in pixels
+	thumbSize = 240
+	// thumbCacheSize is how many thumbnails are kept in memory
+	thumbCacheSize = 48
+	// maxImagePixels protects from images which take gigabytes after decoding
+	maxImagePixels = 40 << 20
+)
+
+var errImageTooBig = errors.New("image is too big")
+
+// isImageFile checks extension of file
+func isImageFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png", ".jpg", ".jpeg", ".gif":
+		return true
+	}
+	return false
+}
+
+// decodeImage decodes png, jpeg or gif (it's first frame)
+func decodeImage(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return nil, errImageTooBig
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// scaleDown makes image fit in max x max square (nearest neighbour, it's enough for previews)
+func scaleDown(img image.Image, max int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= max && h <= max {
+		return img
+	}
+	nw, nh := max, h*max/w
+	if h > w {
+		nw, nh = w*max/h, max
+	}
+	if nw == 0 {
+		nw = 1
+	}
+	if nh == 0 {
+		nh = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, nw, nh))
+	for y := 0; y < nh; y++ {
+		for x := 0; x < nw; x++ {
+			dst.Set(x, y, img.At(b.Min.X+x*w/nw, b.Min.Y+y*h/nh))
+		}
+	}
+	return dst
+}
+
+type thumb struct {
+	op      paint.ImageOp
+	loading bool
+	err     error
+}
+
+// ThumbCache keeps thumbnails of images; least recently used ones are dropped
+type ThumbCache struct {
+	mu    sync.Mutex
+	m     map[string]*thumb
+	order []string // the last one is the most recently used
+	// Invalidate redraws window when thumbnail is loaded
+	Invalidate func()
+}
+
+var thumbs = &ThumbCache{m: make(map[string]*thumb), Invalidate: func() {}}
+
+// Get returns thumbnail of image; it's loaded in background, so ready is false until it's done
+func (tc *ThumbCache) Get(path string) (op paint.ImageOp, ready bool, err error) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if t, ok := tc.m[path]; ok {
+		tc.touch(path)
+		return t.op, !t.loading, t.err
+	}
+	tc.m[path] = &thumb{loading: true}
+	tc.order = append(tc.order, path)
+	go tc.load(path)
+	return paint.ImageOp{}, false, nil
+}
+
+// touch moves path to end of order; must be called with locked mu
+func (tc *ThumbCache) touch(path string) {
+	for i, p := range tc.order {
+		if p == path {
+			tc.order = append(append(tc.order[:i:i], tc.order[i+1:]...), path)
+			return
+		}
+	}
+}
+
+func (tc *ThumbCache) load(path string) {
+	img, err := decodeImage(path)
+	tc.mu.Lock()
+	t := tc.m[path]
+	t.loading, t.err = false, err
+	if err == nil {
+		t.op = paint.NewImageOp(scaleDown(img, thumbSize))
+	} else {
+		errl.Println(err)
+	}
+	for len(tc.order) > thumbCacheSize {
+		oldest := tc.order[0]
+		if tc.m[oldest].loading {
+			break
+		}
+		delete(tc.m, oldest)
+		tc.order = tc.order[1:]
+	}
+	tc.mu.Unlock()
+	tc.Invalidate()
+}
+
+// ImageViewer shows image in full size instead of messages
+type ImageViewer struct {
+	mu       sync.Mutex
+	Path     string
+	img      paint.ImageOp
+	loading  bool
+	err      error
+	CloseBtn *widget.Clickable
+	// Invalidate redraws window when image is loaded
+	Invalidate func()
+}
+
+var viewer = &ImageViewer{CloseBtn: new(widget.Clickable), Invalidate: func() {}}
+
+// Open starts loading of image and shows viewer
+func (v *ImageViewer) Open(path string) {
+	v.mu.Lock()
+	v.Path, v.img, v.loading, v.err = path, paint.ImageOp{}, true, nil
+	v.mu.Unlock()
+	go func() {
+		img, err := decodeImage(path)
+		v.mu.Lock()
+		if v.Path == path { // else other image was opened
+			v.loading, v.err = false, err
+			if err == nil {
+				v.img = paint.NewImageOp(img)
+			}
+		}
+		v.mu.Unlock()
+		v.Invalidate()
+	}()
+}
+
+// IsOpen returns true if viewer shows something
+func (v *ImageViewer) IsOpen() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.Path != ""
+}
+
+// Layout layouts image and close button; click on image closes it too
+func (v *ImageViewer) Layout(gtx C, th T) D {
+	if v.CloseBtn.Clicked() {
+		v.mu.Lock()
+		v.Path, v.img = "", paint.ImageOp{} // to free memory
+		v.mu.Unlock()
+		return D{}
+	}
+	v.mu.Lock()
+	path, img, loading, err := v.Path, v.img, v.loading, v.err
+	v.mu.Unlock()
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+		layout.Rigid(func(gtx C) D {
+			return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+				layout.Rigid(msgIconButton(th, v.CloseBtn, cancelIcon, "Close image").Layout),
+				wspacer,
+				layout.Flexed(1, material.Caption(th, filepath.Base(path)).Layout),
+			)
+		}),
+		hspacer,
+		layout.Flexed(1, func(gtx C) D {
+			switch {
+			case loading:
+				return layout.Center.Layout(gtx, material.Loader(th).Layout)
+			case err != nil:
+				return material.Body2(th, "Can't open image: "+err.Error()).Layout(gtx)
+			}
+			return v.CloseBtn.Layout(gtx, func(gtx C) D {
+				return widget.Image{
+					Src:      img,
+					Fit:      widget.ScaleDown,
+					Position: layout.Center,
+					Scale:    1 / gtx.Metric.PxPerDp, // one pixel of image is one pixel of screen
+				}.Layout(gtx)
+			})
+		}),
+	)
+}
+
+// layoutThumb layouts preview of image of transfer
+func layoutThumb(gtx C, th T, t Transfer) D {
+	if t.LoadImageBtn.Clicked() {
+		transfers.ShowImage(t.ID)
+	}
+	if t.ImageBtn.Clicked() {
+		viewer.Open(t.Path)
+	}
+	if !t.Trusted && !t.ShowImage && conf.ImagesOnlyFromKnown {
+		return layout.Inset{Top: unit.Dp(5)}.Layout(gtx, material.Button(th, t.LoadImageBtn, "Show image").Layout)
+	}
+	op, ready, err := thumbs.Get(t.Path)
+	switch {
+	case err != nil:
+		return material.Caption(th, "can't show image: "+err.Error()).Layout(gtx)
+	case !ready:
+		return material.Caption(th, "loading image...").Layout(gtx)
+	}
+	return layout.Inset{Top: unit.Dp(5)}.Layout(gtx, func(gtx C) D {
+		return t.ImageBtn.Layout(gtx, widget.Image{Src: op, Scale: 1 / gtx.Metric.PxPerDp}.Layout)
+	})
+}
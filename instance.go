@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// lockFile is created by running client near config, so it's per profile;
+// together with ipcSocket it tells that client is already running
+const lockFile = "overmsg.lock"
+
+// lockGrace is how long fresh lock is trusted while it's owner isn't listening socket yet
+const lockGrace = 10 * time.Second
+
+var errAlreadyRunning = errors.New("another client is already running with this profile")
+
+// openArg returns nick from "--open nick" argument
+func openArg(args []string) string {
+	for i, a := range args {
+		if a == "--open" && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(a, "--open=") {
+			return strings.TrimPrefix(a, "--open=")
+		}
+	}
+	return ""
+}
+
+// handOff takes lock of profile; if profile is used by running client, args are
+// sent to it and true is returned, so this process should exit
+func handOff(args []string) bool {
+	for try := 0; try < 2; try++ {
+		f, err := os.OpenFile(lockFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			fmt.Fprintln(f, os.Getpid())
+			f.Close()
+			return false
+		}
+		if !os.IsExist(err) { // it's better to run without lock than not to run
+			errl.Println(err)
+			return false
+		}
+		wait := time.Duration(0)
+		if st, err := os.Stat(lockFile); err == nil && time.Since(st.ModTime()) < lockGrace {
+			wait = lockGrace - time.Since(st.ModTime())
+		}
+		if err := sendArgs(args, wait); err == nil {
+			return true
+		} else if !errors.Is(err, errNoClient) {
+			errl.Println(err)
+			return true // someone listens, so client is running
+		}
+		os.Remove(lockFile) // left by crashed client
+	}
+	return false
+}
+
+// unlock removes lock of profile
+func unlock() {
+	if err := os.Remove(lockFile); err != nil && !os.IsNotExist(err) {
+		errl.Println(err)
+	}
+}
+
+var errNoClient = errors.New("no client listens socket")
+
+// sendArgs asks running client to open chat from args or just to show it's window;
+// it waits for client to start listening for at most wait
+func sendArgs(args []string, wait time.Duration) error {
+	deadline := time.Now().Add(wait)
+	var conn net.Conn
+	for {
+		var err error
+		if conn, err = net.Dial("unix", ipcSocket); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return errNoClient
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	defer conn.Close()
+	req := ipcRequest{Cmd: "focus"}
+	if nick := openArg(args); nick != "" {
+		req = ipcRequest{Cmd: "open", To: nick}
+	}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+	in := bufio.NewScanner(conn)
+	if !in.Scan() {
+		return fmt.Errorf("no answer from running client: %v", in.Err())
+	}
+	var resp ipcResponse
+	if err := json.Unmarshal(in.Bytes(), &resp); err != nil {
+		return err
+	}
+	if !resp.OK {
+		return errors.New(resp.Error)
+	}
+	return nil
+}
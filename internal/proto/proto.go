@@ -0,0 +1,103 @@
+// Package proto is wire format of overmsg which is shared by client and bot package
+package proto
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+)
+
+const (
+	// EnvelopeVersion is version of envelope format which is written
+	EnvelopeVersion = 1
+	// EnvelopePrefix starts last line of message which contains envelope.
+	// It begins with invisible separator, so it won't be typed by accident
+	EnvelopePrefix = "\u2063overmsg:"
+	// TypeText is content type of text messages
+	TypeText = "text"
+)
+
+// Answer is what server answers to HTTP requests
+type Answer struct {
+	Success bool                   `json:"succes"` // it's spelled so by server
+	Error   string                 `json:"error"`
+	Res     map[string]interface{} `json:"result"`
+}
+
+// Envelope is metadata of message. Server knows only text of messages,
+// so envelope is sent as last line of text: old clients show text and
+// strange line after it, new ones hide that line and use it
+type Envelope struct {
+	V       int                        `json:"v"`
+	ID      string                     `json:"id"`
+	Type    string                     `json:"type"`
+	ReplyTo string                     `json:"reply_to,omitempty"`
+	Attrs   map[string]json.RawMessage `json:"attrs,omitempty"`
+}
+
+// SetAttr sets attribute of envelope
+func (e *Envelope) SetAttr(key string, val interface{}) error {
+	dat, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	if e.Attrs == nil {
+		e.Attrs = make(map[string]json.RawMessage)
+	}
+	e.Attrs[key] = dat
+	return nil
+}
+
+// Attr decodes attribute to val; returns false if there's no such attribute
+// or it has wrong type
+func (e Envelope) Attr(key string, val interface{}) bool {
+	dat, ok := e.Attrs[key]
+	if !ok {
+		return false
+	}
+	return json.Unmarshal(dat, val) == nil
+}
+
+// NewMessageID returns random id of message
+func NewMessageID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		panic(err) // it happens only if system is broken
+	}
+	return hex.EncodeToString(b)
+}
+
+// Encode makes text which is sent to server: plain text
+// (fallback for old clients) and envelope after it
+func Encode(txt string, env Envelope) (string, error) {
+	dat, err := json.Marshal(env)
+	if err != nil {
+		return "", err
+	}
+	return txt + "\n" + EnvelopePrefix + string(dat), nil
+}
+
+// Decode splits got text into plain text and envelope.
+// Envelope is nil if message was sent by old client or it is broken
+func Decode(raw string) (string, *Envelope) {
+	var txt, line string
+	if i := strings.LastIndex(raw, "\n"+EnvelopePrefix); i != -1 {
+		txt, line = raw[:i], raw[i+1:]
+	} else if strings.HasPrefix(raw, EnvelopePrefix) {
+		line = raw
+	} else {
+		return raw, nil
+	}
+	var env Envelope
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, EnvelopePrefix)), &env); err != nil {
+		return raw, nil
+	}
+	if env.V < 1 || env.ID == "" {
+		return raw, nil
+	}
+	if env.Type == "" {
+		env.Type = TypeText
+	}
+	return txt, &env
+}
@@ -0,0 +1,57 @@
+package proto
+
+import (
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		txt     string
+		id, typ string
+	}{
+		{"plain text", "hello", "hello", "", ""},
+		{"envelope", "hi\n" + EnvelopePrefix + `{"v":1,"id":"a1","type":"file"}`, "hi", "a1", "file"},
+		{"default type", "hi\n" + EnvelopePrefix + `{"v":1,"id":"a1"}`, "hi", "a1", TypeText},
+		{"only envelope", EnvelopePrefix + `{"v":1,"id":"a1","type":"typing"}`, "", "a1", "typing"},
+		{"multiline text", "a\nb\n" + EnvelopePrefix + `{"v":1,"id":"a1","type":"text"}`, "a\nb", "a1", TypeText},
+		{"broken json", "hi\n" + EnvelopePrefix + `{"v":1,`, "hi\n" + EnvelopePrefix + `{"v":1,`, "", ""},
+		{"no id", "hi\n" + EnvelopePrefix + `{"v":1}`, "hi\n" + EnvelopePrefix + `{"v":1}`, "", ""},
+		{"no version", "hi\n" + EnvelopePrefix + `{"id":"a1"}`, "hi\n" + EnvelopePrefix + `{"id":"a1"}`, "", ""},
+		{"prefix inside line", "see " + EnvelopePrefix + `{"v":1,"id":"a1"}`, "see " + EnvelopePrefix + `{"v":1,"id":"a1"}`, "", ""},
+	}
+	for _, tt := range tests {
+		txt, env := Decode(tt.raw)
+		if txt != tt.txt {
+			t.Errorf("%s: text is %q, want %q", tt.name, txt, tt.txt)
+		}
+		switch {
+		case tt.id == "" && env != nil:
+			t.Errorf("%s: got envelope %+v, want none", tt.name, *env)
+		case tt.id != "" && env == nil:
+			t.Errorf("%s: got no envelope", tt.name)
+		case env != nil && (env.ID != tt.id || env.Type != tt.typ):
+			t.Errorf("%s: envelope is %+v, want id %q and type %q", tt.name, *env, tt.id, tt.typ)
+		}
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	env := Envelope{V: EnvelopeVersion, ID: NewMessageID(), Type: TypeText, ReplyTo: "b2"}
+	if err := env.SetAttr("group", map[string]string{"id": "g"}); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := Encode("multi\nline", env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txt, got := Decode(raw)
+	if txt != "multi\nline" || got == nil || got.ID != env.ID || got.ReplyTo != "b2" {
+		t.Fatalf("Decode(Encode()) = %q, %+v", txt, got)
+	}
+	var g map[string]string
+	if !got.Attr("group", &g) || g["id"] != "g" {
+		t.Errorf("attribute is lost: %+v", got.Attrs)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// ipcSocket is Unix socket of running client; it's near config, so every profile has own one.
+// It's in ipcDir, which only user can access, so other users can't connect to it
+const (
+	ipcDir    = "ipc"
+	ipcSocket = ipcDir + "/overmsg.sock"
+)
+
+var errNotLogged = errors.New("not logged in")
+
+// ipcRequest is line sent to client's socket
+type ipcRequest struct {
+	Cmd  string `json:"cmd"` // send, chats, unread, subscribe, focus or open
+	To   string `json:"to,omitempty"`
+	Text string `json:"text,omitempty"`
+}
+
+// ipcResponse is answer to request
+type ipcResponse struct {
+	OK     bool      `json:"ok"`
+	Error  string    `json:"error,omitempty"`
+	ID     string    `json:"id,omitempty"` // of sent message
+	Chats  []ipcChat `json:"chats,omitempty"`
+	Unread *int      `json:"unread,omitempty"`
+}
+
+// ipcChat is chat in list
+type ipcChat struct {
+	Peer   string `json:"peer"`
+	Title  string `json:"title"`
+	Unread int    `json:"unread"`
+	Online bool   `json:"online"`
+}
+
+// ipcEvent is got message sent to subscribers
+type ipcEvent struct {
+	ID   string    `json:"id"`
+	From string    `json:"from"`
+	Chat string    `json:"chat"` // peer or group
+	Type string    `json:"type"`
+	Text string    `json:"text"`
+	Time time.Time `json:"time"`
+}
+
+// IPC serves local socket
+type IPC struct {
+	mu   sync.Mutex
+	subs map[chan ipcEvent]struct{}
+	// Focus shows window; Open opens chat with nick. They are called by
+	// another started client, which hands off it's arguments
+	Focus func()
+	Open  func(nick string) error
+}
+
+var ipc = &IPC{
+	subs:  make(map[chan ipcEvent]struct{}),
+	Focus: func() {},
+	Open:  func(string) error { return errNotLogged },
+}
+
+// Unread returns how many got messages weren't seen
+func (c *Chat) Unread() int {
+	var n int
+	for _, g := range c.Messages {
+		if g.From != conf.Name && g.Type != ctSystem && !isControlType(g.Type) && !g.Seen {
+			n++
+		}
+	}
+	return n
+}
+
+// Publish sends got message to subscribers; slow subscribers lose events
+func (s *IPC) Publish(g GUIMessage, chat string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.subs {
+		select {
+		case ch <- ipcEvent{ID: g.ID, From: g.From, Chat: chat, Type: g.Type, Text: g.Text, Time: g.Time}:
+		default:
+		}
+	}
+}
+
+// Subscribe returns channel of got messages; cancel must be called when they aren't needed
+func (s *IPC) Subscribe() (ch chan ipcEvent, cancel func()) {
+	ch = make(chan ipcEvent, recvQueueSize)
+	s.mu.Lock()
+	s.subs[ch] = struct{}{}
+	s.mu.Unlock()
+	return ch, func() {
+		s.mu.Lock()
+		delete(s.subs, ch)
+		s.mu.Unlock()
+	}
+}
+
+// Serve listens socket until error
+func (s *IPC) Serve() error {
+	if conn, err := net.Dial("unix", ipcSocket); err == nil {
+		conn.Close()
+		return errAlreadyRunning
+	}
+	if err := os.MkdirAll(ipcDir, 0700); err != nil {
+		return err
+	}
+	if err := os.Chmod(ipcDir, 0700); err != nil { // it may be left with wider mode; it fails if directory is not ours
+		return err
+	}
+	os.Remove(ipcSocket) // left by crashed client
+	l, err := net.Listen("unix", ipcSocket)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *IPC) handle(conn net.Conn) {
+	defer conn.Close()
+	enc := json.NewEncoder(conn)
+	in := bufio.NewScanner(conn)
+	in.Buffer(nil, 1<<20)
+	for in.Scan() {
+		var req ipcRequest
+		if err := json.Unmarshal(in.Bytes(), &req); err != nil {
+			enc.Encode(ipcResponse{Error: "bad request: " + err.Error()})
+			continue
+		}
+		if req.Cmd == "subscribe" {
+			s.subscribe(conn, enc)
+			return
+		}
+		resp := s.do(req)
+		if err := enc.Encode(resp); err != nil {
+			errl.Println(err)
+			return
+		}
+	}
+}
+
+// subscribe writes got messages to conn until it's closed
+func (s *IPC) subscribe(conn net.Conn, enc *json.Encoder) {
+	ch, cancel := s.Subscribe()
+	defer cancel()
+	if err := enc.Encode(ipcResponse{OK: true}); err != nil {
+		return
+	}
+	closed := make(chan struct{})
+	go func() { // subscriber sends nothing, so read returns only when it's gone
+		var b [1]byte
+		for {
+			if _, err := conn.Read(b[:]); err != nil {
+				close(closed)
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case ev := <-ch:
+			if err := enc.Encode(ev); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// do runs request on UI goroutine
+func (s *IPC) do(req ipcRequest) ipcResponse {
+	if req.Cmd == "focus" || req.Cmd == "open" {
+		s.Focus() // window is shown even if user isn't logged in, so user can log in
+		if req.Cmd == "focus" {
+			return ipcResponse{OK: true}
+		}
+	}
+	if store.Token() == "" {
+		return ipcResponse{Error: errNotLogged.Error()}
+	}
+	res := make(chan ipcResponse, 1)
+	switch req.Cmd {
+	case "open":
+		if !isSafeName(req.To) || req.To == store.Name() {
+			return ipcResponse{Error: "bad peer name"}
+		}
+		if err := s.Open(req.To); err != nil {
+			return ipcResponse{Error: err.Error()}
+		}
+		return ipcResponse{OK: true}
+	case "send":
+		if !isSafeName(req.To) || req.To == store.Name() {
+			return ipcResponse{Error: "bad peer name"}
+		}
+		if req.Text == "" || len([]rune(req.Text)) > maxTextLen() {
+			return ipcResponse{Error: "bad length of text"}
+		}
+		id := make(chan string, 1)
+		store.Do(func() {
+			c := GetByPN(store.Chats, req.To)
+			if c.PeerName == "" {
+				c = newChat(req.To)
+				store.Chats = append(store.Chats, c)
+			}
+			c.SendText(req.Text, "", func(err error) {
+				if err != nil {
+					res <- ipcResponse{Error: err.Error()}
+					return
+				}
+				res <- ipcResponse{OK: true}
+			})
+			id <- c.Messages[len(c.Messages)-1].ID // local echo
+		})
+		r := <-res
+		if r.OK {
+			r.ID = <-id
+		}
+		return r
+	case "chats":
+		store.Do(func() {
+			chats := make([]ipcChat, 0, len(store.Chats))
+			for _, c := range store.Chats {
+				chats = append(chats, ipcChat{
+					Peer:   c.PeerName,
+					Title:  c.Title(),
+					Unread: c.Unread(),
+					Online: presence.Get(c.PeerName).Online,
+				})
+			}
+			res <- ipcResponse{OK: true, Chats: chats}
+		})
+	case "unread":
+		store.Do(func() {
+			var n int
+			for _, c := range store.Chats {
+				if req.To == "" || c.PeerName == req.To {
+					n += c.Unread()
+				}
+			}
+			res <- ipcResponse{OK: true, Unread: &n}
+		})
+	default:
+		return ipcResponse{Error: "unknown command " + req.Cmd}
+	}
+	return <-res
+}
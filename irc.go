@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	// ircServerName is name of gateway in IRC replies
+	ircServerName = "overmsg"
+	// maxIRCLine is max length of IRC line in bytes with CRLF
+	maxIRCLine = 512
+)
+
+var (
+	errNotLoopback = errors.New("IRC gateway may listen only on loopback address")
+	errNoIRCPass   = errors.New("IRC gateway needs irc_pass in config")
+)
+
+// serveIRC runs IRC gateway: peers are IRC queries and messages to them are sent
+// with user's account. It listens only on loopback
+func serveIRC(addr string) error {
+	if conf.IRCPass == "" {
+		return errNoIRCPass
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return errNotLoopback
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go newIRCSession(conn).run()
+	}
+}
+
+// ircSession is connection of one IRC client
+type ircSession struct {
+	conn net.Conn
+	wmu  sync.Mutex
+	nick string // what client calls itself until it's registered
+	pass bool
+	user bool
+	reg  bool
+	done chan struct{} // closed when client is gone
+}
+
+func newIRCSession(conn net.Conn) *ircSession {
+	return &ircSession{conn: conn, nick: "*", done: make(chan struct{})}
+}
+
+// send writes line to client
+func (s *ircSession) send(format string, a ...interface{}) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	fmt.Fprintf(s.conn, format+"\r\n", a...)
+}
+
+// reply writes numeric reply
+func (s *ircSession) reply(code, format string, a ...interface{}) {
+	s.send(":%s %s %s "+format, append([]interface{}{ircServerName, code, s.nick}, a...)...)
+}
+
+// parseIRC splits line to command and params; trailing param may contain spaces
+func parseIRC(line string) (cmd string, params []string) {
+	if strings.HasPrefix(line, ":") { // prefix of client is ignored
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			line = line[i+1:]
+		} else {
+			return "", nil
+		}
+	}
+	for line != "" {
+		if strings.HasPrefix(line, ":") {
+			params = append(params, line[1:])
+			break
+		}
+		i := strings.IndexByte(line, ' ')
+		if i < 0 {
+			params = append(params, line)
+			break
+		}
+		if i > 0 {
+			params = append(params, line[:i])
+		}
+		line = line[i+1:]
+	}
+	if len(params) == 0 {
+		return "", nil
+	}
+	return strings.ToUpper(params[0]), params[1:]
+}
+
+func (s *ircSession) run() {
+	defer s.conn.Close()
+	var cancel func()
+	defer func() {
+		close(s.done)
+		if cancel != nil {
+			cancel()
+		}
+	}()
+	in := bufio.NewScanner(s.conn)
+	for in.Scan() {
+		cmd, params := parseIRC(strings.TrimRight(in.Text(), "\r"))
+		switch cmd {
+		case "":
+		case "CAP":
+			if len(params) > 0 && strings.ToUpper(params[0]) == "LS" {
+				s.send(":%s CAP * LS :", ircServerName)
+			}
+		case "PASS":
+			if len(params) == 0 {
+				s.reply("461", "PASS :Not enough parameters")
+				continue
+			}
+			if subtle.ConstantTimeCompare([]byte(params[0]), []byte(conf.IRCPass)) != 1 {
+				s.reply("464", ":Password incorrect")
+				return
+			}
+			s.pass = true
+		case "NICK":
+			if len(params) > 0 && !s.reg {
+				s.nick = params[0]
+			}
+		case "USER":
+			s.user = true
+		case "PING":
+			s.send(":%s PONG %s :%s", ircServerName, ircServerName, strings.Join(params, " "))
+		case "QUIT":
+			return
+		default:
+			if !s.reg {
+				s.reply("451", ":You have not registered")
+				continue
+			}
+			s.handle(cmd, params)
+		}
+		if !s.reg && s.user && s.nick != "*" {
+			if !s.pass {
+				s.reply("464", ":Password incorrect")
+				return
+			}
+			if store.Token() == "" {
+				s.send("ERROR :Log in to overmsg first")
+				return
+			}
+			s.register()
+			var events chan ipcEvent
+			events, cancel = ipc.Subscribe()
+			go s.forward(events)
+		}
+	}
+}
+
+// register greets client and gives it nick of account
+func (s *ircSession) register() {
+	s.reg = true
+	name := store.Name()
+	if s.nick != name {
+		s.send(":%s NICK %s", s.nick, name)
+		s.nick = name
+	}
+	s.reply("001", ":Welcome to overmsg, %s", name)
+	s.reply("002", ":Your host is %s", ircServerName)
+	s.reply("003", ":Peers are queries; groups aren't supported")
+	s.reply("004", "%s overmsg-client o o", ircServerName)
+	s.reply("422", ":MOTD File is missing")
+}
+
+// handle runs command of registered client
+func (s *ircSession) handle(cmd string, params []string) {
+	switch cmd {
+	case "PRIVMSG", "NOTICE":
+		if len(params) < 2 {
+			s.reply("461", "%s :Not enough parameters", cmd)
+			return
+		}
+		s.privmsg(params[0], params[1], cmd == "PRIVMSG")
+	case "WHOIS":
+		if len(params) == 0 {
+			s.reply("431", ":No nickname given")
+			return
+		}
+		s.whois(params[len(params)-1])
+	case "MODE", "USERHOST", "WHO", "AWAY", "ISON":
+		// clients send them on connect; there is nothing to tell
+	default:
+		s.reply("421", "%s :Unknown command", cmd)
+	}
+}
+
+// privmsg sends text to peer through it's chat
+func (s *ircSession) privmsg(to, txt string, answer bool) {
+	if strings.HasPrefix(to, "#") || strings.HasPrefix(to, "&") {
+		if answer {
+			s.reply("403", "%s :No such channel", to)
+		}
+		return
+	}
+	if !isSafeName(to) || to == store.Name() {
+		if answer {
+			s.reply("401", "%s :No such nick", to)
+		}
+		return
+	}
+	if strings.HasPrefix(txt, "\x01ACTION ") { // CTCP action is /me of IRC
+		txt = "* " + store.Name() + " " + strings.TrimSuffix(txt[len("\x01ACTION "):], "\x01")
+	}
+	if txt == "" || len([]rune(txt)) > maxTextLen() {
+		s.reply("417", ":Bad length of text")
+		return
+	}
+	store.Do(func() {
+		c := GetByPN(store.Chats, to)
+		if c.PeerName == "" {
+			c = newChat(to)
+			store.Chats = append(store.Chats, c)
+		}
+		c.SendText(txt, "", func(err error) {
+			if err != nil && answer {
+				s.send(":%s NOTICE %s :Message to %s isn't sent: %s", ircServerName, s.nick, to, ircClean(err.Error()))
+			}
+		})
+	})
+}
+
+// whois tells if peer exists and is online
+func (s *ircSession) whois(nick string) {
+	if !isSafeName(nick) {
+		s.reply("401", "%s :No such nick", nick)
+		return
+	}
+	is, exs, err := isOnline(nick)
+	switch {
+	case err != nil:
+		errl.Println(err)
+		s.reply("402", "%s :Error asking server", nick)
+		return
+	case !exs:
+		s.reply("401", "%s :No such nick", nick)
+		return
+	}
+	presence.Set(nick, is)
+	s.reply("311", "%s %s %s * :%s", nick, nick, ircServerName, ircClean(contacts.DisplayName(nick)))
+	if !is {
+		st := lastSeenStr(presence.Get(nick))
+		if st == "" {
+			st = "offline"
+		}
+		s.reply("301", "%s :%s", nick, st)
+	}
+	s.reply("318", "%s :End of /WHOIS list", nick)
+}
+
+// forward sends got messages to client as PRIVMSGs
+func (s *ircSession) forward(events chan ipcEvent) {
+	for {
+		var ev ipcEvent
+		select {
+		case ev = <-events:
+		case <-s.done:
+			return
+		}
+		txt := ev.Text
+		if strings.HasPrefix(ev.Chat, groupPrefix) {
+			title := "group"
+			if g, ok := groups.Get(strings.TrimPrefix(ev.Chat, groupPrefix)); ok {
+				title = g.Name
+			}
+			txt = "[" + title + "] " + txt
+		}
+		prefix := fmt.Sprintf(":%s!%s@%s PRIVMSG %s :", ev.From, ev.From, ircServerName, s.nick)
+		for _, line := range strings.Split(txt, "\n") {
+			for _, part := range splitIRC(ircClean(line), maxIRCLine-len(prefix)-2) {
+				s.send("%s%s", prefix, part)
+			}
+		}
+	}
+}
+
+// ircClean replaces symbols which break IRC lines with spaces
+func ircClean(txt string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' || r == 0 {
+			return ' '
+		}
+		return r
+	}, txt)
+}
+
+// splitIRC splits text to parts of at most n bytes without breaking runes; empty text has no parts
+func splitIRC(txt string, n int) []string {
+	var parts []string
+	for strings.TrimSpace(txt) != "" {
+		end := len(txt)
+		if end > n {
+			end = n
+			for end > 0 && !utf8.RuneStart(txt[end]) {
+				end--
+			}
+			if end == 0 { // n is less than rune
+				_, end = utf8.DecodeRuneInString(txt)
+			}
+		}
+		parts = append(parts, txt[:end])
+		txt = txt[end:]
+	}
+	return parts
+}
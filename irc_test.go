@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"gioui.org/widget"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestIRCForwardAccepted checks that messages of strangers and ones suppressed
+// by flood limiter aren't forwarded to IRC client
+func TestIRCForwardAccepted(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil { // history is written to working directory
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	errl = log.New(ioutil.Discard, "", 0)
+	store.SetAccount("me", "token")
+	defer func() { store.Chats = []*Chat{} }()
+
+	server, client := net.Pipe()
+	defer client.Close()
+	s := newIRCSession(server)
+	s.nick = "me"
+	events, cancel := ipc.Subscribe()
+	defer cancel()
+	go s.forward(events)
+	defer close(s.done)
+	lines := make(chan string, 2*floodBurst)
+	go func() {
+		in := bufio.NewScanner(client)
+		for in.Scan() {
+			lines <- in.Text()
+		}
+	}()
+
+	// test is UI goroutine, so handleMessage is called directly
+	store.Chats = append(store.Chats, &Chat{PeerName: "ircfriend", Button: new(widget.Clickable), OlderBtn: new(widget.Clickable)})
+	handleMessage("ircstranger", GUIMessage{ID: "s1", From: "ircstranger", Text: "buy now", Type: ctText, Time: time.Now()}, nil)
+	for i := 0; i < floodBurst+5; i++ {
+		id := fmt.Sprintf("f%d", i)
+		handleMessage("ircfriend", GUIMessage{ID: id, From: "ircfriend", Text: "msg " + id, Type: ctText, Time: time.Now()}, nil)
+	}
+
+	var got []string
+	timeout := time.After(2 * time.Second)
+	for len(got) < floodBurst {
+		select {
+		case l := <-lines:
+			got = append(got, l)
+		case <-timeout:
+			t.Fatalf("got %d lines, want %d: %q", len(got), floodBurst, got)
+		}
+	}
+	select {
+	case l := <-lines:
+		got = append(got, l)
+	case <-time.After(200 * time.Millisecond):
+	}
+	history.Flush()
+	if len(got) != floodBurst {
+		t.Errorf("%d messages are forwarded, want %d: %q", len(got), floodBurst, got)
+	}
+	for _, l := range got {
+		if strings.Contains(l, "ircstranger") {
+			t.Errorf("message of stranger is forwarded: %q", l)
+		}
+		if !strings.HasPrefix(l, ":ircfriend!") {
+			t.Errorf("unexpected line %q", l)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"gioui.org/io/pointer"
+	"gioui.org/op/clip"
+	"github.com/sqweek/dialog"
+	"image"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+var urlRe = regexp.MustCompile(`https?://[^\s<>"]+[^\s<>".,;:!?)\]}'*_~]`)
+
+// linkTag is tag of link's pointer events; links with same url share it
+type linkTag string
+
+// splitLinks moves urls from text spans to their own spans
+func splitLinks(spans []mdSpan) []mdSpan {
+	res := make([]mdSpan, 0, len(spans))
+	for _, sp := range spans {
+		if sp.Style.Code {
+			res = append(res, sp)
+			continue
+		}
+		last := 0
+		for _, loc := range urlRe.FindAllStringIndex(sp.Text, -1) {
+			if loc[0] > last {
+				res = append(res, mdSpan{sp.Text[last:loc[0]], sp.Style})
+			}
+			st := sp.Style
+			st.Link = true
+			res = append(res, mdSpan{sp.Text[loc[0]:loc[1]], st})
+			last = loc[1]
+		}
+		if last < len(sp.Text) {
+			res = append(res, mdSpan{sp.Text[last:], sp.Style})
+		}
+	}
+	return res
+}
+
+// layoutLinkArea makes rectangle clickable and opens url on click
+func layoutLinkArea(gtx C, r image.Rectangle, url string) {
+	tag := linkTag(url)
+	for _, e := range gtx.Events(tag) {
+		if e, ok := e.(pointer.Event); ok && e.Type == pointer.Release {
+			go func() {
+				if err := openURL(url); err != nil {
+					errl.Println(err)
+					dialog.Message("Error opening link").Title("Error!!1").Error()
+				}
+			}()
+		}
+	}
+	area := clip.Rect(r).Push(gtx.Ops)
+	pointer.InputOp{Tag: tag, Types: pointer.Press | pointer.Release}.Add(gtx.Ops)
+	pointer.CursorNameOp{Name: pointer.CursorPointer}.Add(gtx.Ops)
+	area.Pop()
+}
+
+// openURL opens url with conf.BrowserCmd or with system's default browser
+func openURL(url string) error {
+	if !urlRe.MatchString(url) {
+		return errors.New("not a link: " + url)
+	}
+	args := strings.Fields(conf.BrowserCmd)
+	if len(args) == 0 {
+		switch runtime.GOOS {
+		case "windows":
+			args = []string{"rundll32", "url.dll,FileProtocolHandler"}
+		case "darwin":
+			args = []string{"open"}
+		default:
+			args = []string{"xdg-open"}
+		}
+	}
+	cmd := exec.Command(args[0], append(args[1:], url)...)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait() // to not leave zombies
+	return nil
+}
@@ -13,7 +13,8 @@ var (
 	debl, errl *log.Logger
 )
 
-func init() {
+// setup opens log, hands arguments off to running client if there is one, and reads config
+func setup() {
 	debl = log.New(os.Stdout, "[DEBUG]\t", log.Ldate|log.Ltime|log.Lshortfile)
 	errlf, err := os.OpenFile("errors.log", os.O_APPEND|os.O_CREATE, 0777)
 	if err != nil {
@@ -22,6 +23,9 @@ func init() {
 		os.Exit(1)
 	}
 	errl = log.New(errlf, "[ERROR]\t", log.Ldate|log.Ltime|log.Lshortfile)
+	if handOff(os.Args[1:]) { // profile is used by running client, it got our arguments
+		os.Exit(0)
+	}
 	initConfig()
 	initAPI()
 }
@@ -29,6 +33,7 @@ func init() {
 func work(ui *UI) {
 	defer func() {
 		if e := recover(); e != nil {
+			unlock()
 			errl.Println(e)
 			dialog.Message("Fatal error :(((((((((((").Title("ERROR!!!!!!!!!!!!!!!").Error()
 			os.Exit(1)
@@ -41,10 +46,13 @@ func work(ui *UI) {
 		app.MinSize(fsize[0], fsize[1]),
 	}
 	w := app.NewWindow(options...)
-	if err := ui.Run(w); err != nil {
-		if err == errSAW {
-			w = nil
-		}
+	err := ui.Run(w)
+	if err != errSAW { // else lock belongs to another client
+		presence.Save()
+		history.Flush()
+		unlock()
+	}
+	if err != nil {
 		errl.Println(err)
 		dialog.Message("Error: %v", err).Title("Error!!1").Error()
 		os.Exit(1)
@@ -53,6 +61,10 @@ func work(ui *UI) {
 }
 
 func main() {
+	if isCtl() {
+		os.Exit(ctlMain(os.Args[2:]))
+	}
+	setup()
 	ui := NewUI()
 	go work(ui)
 	app.Main()
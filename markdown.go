@@ -0,0 +1,369 @@
+package main
+
+import (
+	"gioui.org/f32"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/text"
+	"gioui.org/unit"
+	"gioui.org/widget/material"
+	"image"
+	"image/color"
+	"strings"
+	"unicode"
+)
+
+// mdStyle is style of piece of message text
+type mdStyle struct {
+	Bold   bool
+	Italic bool
+	Code   bool
+	Strike bool
+	Link   bool
+}
+
+// mdSpan is piece of text with one style
+type mdSpan struct {
+	Text  string
+	Style mdStyle
+}
+
+type mdBlockKind int
+
+const (
+	mdPara mdBlockKind = iota
+	mdQuote
+	mdCode
+)
+
+// mdBlock is paragraph, quote or code block of message
+type mdBlock struct {
+	Kind  mdBlockKind
+	Lines [][]mdSpan // for paragraphs and quotes
+	Code  string     // for code blocks
+}
+
+// parseMarkdown splits message into blocks. Supported only small subset:
+// **bold**, *italic* (or _italic_), `code`, ~~strike~~, ```code blocks``` and > quotes
+func parseMarkdown(s string) []mdBlock {
+	var (
+		blocks []mdBlock
+		code   []string
+		inCode bool
+	)
+	addLine := func(kind mdBlockKind, line string) {
+		if l := len(blocks); l != 0 && blocks[l-1].Kind == kind {
+			blocks[l-1].Lines = append(blocks[l-1].Lines, parseInline(line))
+			return
+		}
+		blocks = append(blocks, mdBlock{Kind: kind, Lines: [][]mdSpan{parseInline(line)}})
+	}
+	for _, line := range strings.Split(s, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			if inCode {
+				blocks = append(blocks, mdBlock{Kind: mdCode, Code: strings.Join(code, "\n")})
+				code = nil
+			}
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			code = append(code, line)
+			continue
+		}
+		if strings.HasPrefix(line, ">") {
+			addLine(mdQuote, strings.TrimPrefix(strings.TrimPrefix(line, ">"), " "))
+			continue
+		}
+		addLine(mdPara, line)
+	}
+	if inCode { // unclosed block is code until end of message
+		blocks = append(blocks, mdBlock{Kind: mdCode, Code: strings.Join(code, "\n")})
+	}
+	return blocks
+}
+
+// parseInline splits line into spans by inline markers.
+// Marker is used only if it has pair, else it is just text
+func parseInline(s string) []mdSpan {
+	var (
+		spans []mdSpan
+		style mdStyle
+		buf   strings.Builder
+	)
+	flush := func() {
+		if buf.Len() != 0 {
+			spans = append(spans, mdSpan{buf.String(), style})
+			buf.Reset()
+		}
+	}
+	isWord := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }
+	rs := []rune(s)
+	// last positions of markers tell at once if marker has pair after it
+	last := map[string]int{"`": -1, "*": -1, "_": -1, "**": -1, "~~": -1}
+	for i, r := range rs {
+		switch r {
+		case '`', '*', '_':
+			last[string(r)] = i
+		}
+		if i > 0 && rs[i-1] == r && (r == '*' || r == '~') {
+			last[string([]rune{r, r})] = i - 1
+		}
+	}
+	isPair := func(i int, r rune) bool { return i+1 < len(rs) && rs[i] == r && rs[i+1] == r }
+	for i := 0; i < len(rs); i++ {
+		switch {
+		case rs[i] == '`':
+			if last["`"] <= i+1 {
+				break
+			}
+			end := i + 1
+			for rs[end] != '`' {
+				end++
+			}
+			if end == i+1 {
+				break
+			}
+			flush()
+			spans = append(spans, mdSpan{string(rs[i+1 : end]), mdStyle{Code: true}})
+			i = end
+			continue
+		case isPair(i, '*'):
+			if style.Bold || last["**"] >= i+2 {
+				flush()
+				style.Bold = !style.Bold
+				i++
+				continue
+			}
+		case isPair(i, '~'):
+			if style.Strike || last["~~"] >= i+2 {
+				flush()
+				style.Strike = !style.Strike
+				i++
+				continue
+			}
+		case rs[i] == '*':
+			if style.Italic || last["*"] > i {
+				flush()
+				style.Italic = !style.Italic
+				continue
+			}
+		case rs[i] == '_':
+			// snake_case_words shouldn't become italic
+			prevWord := i > 0 && isWord(rs[i-1])
+			nextWord := i+1 < len(rs) && isWord(rs[i+1])
+			if (style.Italic && !nextWord) || (!style.Italic && !prevWord && last["_"] > i) {
+				flush()
+				style.Italic = !style.Italic
+				continue
+			}
+		}
+		buf.WriteRune(rs[i])
+	}
+	flush()
+	return splitLinks(spans)
+}
+
+// layoutMarkdown layouts message text parsed by parseMarkdown
+func layoutMarkdown(gtx C, th T, size unit.Value, blocks []mdBlock) D {
+	children := make([]layout.FlexChild, 0, 2*len(blocks))
+	for i := range blocks {
+		b := blocks[i]
+		if i != 0 {
+			children = append(children, layout.Rigid(layout.Spacer{Height: unit.Dp(4)}.Layout))
+		}
+		children = append(children, layout.Rigid(func(gtx C) D {
+			switch b.Kind {
+			case mdCode:
+				return layoutCodeBlock(gtx, th, size, b.Code)
+			case mdQuote:
+				return layoutQuote(gtx, th, size, b.Lines)
+			}
+			return layoutLines(gtx, th, size, th.Fg, b.Lines)
+		}))
+	}
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx, children...)
+}
+
+func layoutCodeBlock(gtx C, th T, size unit.Value, code string) D {
+	macro := op.Record(gtx.Ops)
+	dims := layout.UniformInset(unit.Dp(5)).Layout(gtx, func(gtx C) D {
+		l := material.Label(th, size, code)
+		l.Font.Variant = "Mono"
+		return l.Layout(gtx)
+	})
+	call := macro.Stop()
+	paint.FillShape(gtx.Ops, mutedColor(th.Fg, 0x20), clip.UniformRRect(
+		f32.Rectangle{Max: layout.FPt(dims.Size)}, float32(gtx.Px(unit.Dp(3))),
+	).Op(gtx.Ops))
+	call.Add(gtx.Ops)
+	return dims
+}
+
+func layoutQuote(gtx C, th T, size unit.Value, lines [][]mdSpan) D {
+	return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+		layout.Rigid(func(gtx C) D {
+			// bar is drawn by second child, because only it knows height
+			return D{Size: image.Pt(gtx.Px(unit.Dp(3)), 0)}
+		}),
+		layout.Rigid(layout.Spacer{Width: unit.Dp(7)}.Layout),
+		layout.Flexed(1, func(gtx C) D {
+			dims := layoutLines(gtx, th, size, mutedColor(th.Fg, 0xb0), lines)
+			bar := image.Rect(-gtx.Px(unit.Dp(10)), 0, -gtx.Px(unit.Dp(7)), dims.Size.Y)
+			paint.FillShape(gtx.Ops, th.ContrastBg, clip.Rect(bar).Op())
+			return dims
+		}),
+	)
+}
+
+// word is measured piece of span which can be moved to next line
+type word struct {
+	call   op.CallOp
+	dims   D
+	space  bool // is there space before word
+	strike bool
+	code   bool
+	link   string
+}
+
+// layoutLines layouts spans wrapping them by words
+func layoutLines(gtx C, th T, size unit.Value, col color.NRGBA, lines [][]mdSpan) D {
+	var (
+		maxX   = gtx.Constraints.Max.X
+		spaceW = gtx.Px(size) * 3 / 10
+		width  int
+		y      int
+	)
+	gx := gtx
+	gx.Constraints.Min = image.Point{}
+	for _, spans := range lines {
+		words := measureWords(gx, th, size, col, spans)
+		for len(words) != 0 {
+			// find how many words fit in line
+			n, x := 0, 0
+			for n < len(words) {
+				w := words[n].dims.Size.X
+				if n != 0 && words[n].space {
+					w += spaceW
+				}
+				if n != 0 && x+w > maxX {
+					break
+				}
+				x += w
+				n++
+			}
+			var ascent, descent int
+			for _, w := range words[:n] {
+				a := w.dims.Size.Y - w.dims.Baseline
+				if a > ascent {
+					ascent = a
+				}
+				if d := w.dims.Baseline; d > descent {
+					descent = d
+				}
+			}
+			x = 0
+			for i, w := range words[:n] {
+				if i != 0 && w.space {
+					x += spaceW
+				}
+				top := y + ascent - (w.dims.Size.Y - w.dims.Baseline)
+				r := image.Rectangle{Min: image.Pt(x, top), Max: image.Pt(x, top).Add(w.dims.Size)}
+				if w.code {
+					paint.FillShape(gtx.Ops, mutedColor(col, 0x20), clip.Rect(r).Op())
+				}
+				stack := op.Offset(layout.FPt(r.Min)).Push(gtx.Ops)
+				w.call.Add(gtx.Ops)
+				stack.Pop()
+				if w.strike {
+					mid := y + ascent*2/3
+					paint.FillShape(gtx.Ops, col, clip.Rect{
+						Min: image.Pt(r.Min.X, mid),
+						Max: image.Pt(r.Max.X, mid+gtx.Px(unit.Dp(1))),
+					}.Op())
+				}
+				if w.link != "" {
+					paint.FillShape(gtx.Ops, th.ContrastBg, clip.Rect{
+						Min: image.Pt(r.Min.X, y+ascent+1),
+						Max: image.Pt(r.Max.X, y+ascent+1+gtx.Px(unit.Dp(1))),
+					}.Op())
+					layoutLinkArea(gtx, r, w.link)
+				}
+				x = r.Max.X
+			}
+			if x > width {
+				width = x
+			}
+			y += ascent + descent
+			words = words[n:]
+		}
+	}
+	return D{Size: gtx.Constraints.Constrain(image.Pt(width, y))}
+}
+
+// measureWords records every word of spans
+func measureWords(gtx C, th T, size unit.Value, col color.NRGBA, spans []mdSpan) []word {
+	var (
+		words []word
+		space bool
+	)
+	add := func(s string, st mdStyle, sp bool) {
+		l := material.Label(th, size, s)
+		l.Color = col
+		if st.Link {
+			l.Color = th.ContrastBg
+		}
+		if st.Bold {
+			l.Font.Weight = text.Bold
+		}
+		if st.Italic {
+			l.Font.Style = text.Italic
+		}
+		if st.Code {
+			l.Font.Variant = "Mono"
+		}
+		macro := op.Record(gtx.Ops)
+		dims := l.Layout(gtx)
+		w := word{macro.Stop(), dims, sp, st.Strike, st.Code, ""}
+		if st.Link {
+			w.link = s
+		}
+		words = append(words, w)
+	}
+	for _, sp := range spans {
+		if sp.Style.Code || sp.Style.Link { // code and links are not splitted
+			add(sp.Text, sp.Style, space)
+			space = false
+			continue
+		}
+		rs := []rune(sp.Text)
+		start := -1
+		for i := 0; i <= len(rs); i++ {
+			if i == len(rs) || unicode.IsSpace(rs[i]) {
+				if start != -1 {
+					add(string(rs[start:i]), sp.Style, space)
+					space = false
+					start = -1
+				}
+				if i != len(rs) {
+					space = true
+				}
+				continue
+			}
+			if start == -1 {
+				start = i
+			}
+		}
+	}
+	if len(words) == 0 { // empty line is still line
+		add(" ", mdStyle{}, false)
+	}
+	return words
+}
+
+func mutedColor(c color.NRGBA, a uint8) color.NRGBA {
+	c.A = a
+	return c
+}
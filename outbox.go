@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/dikey0ficial/overmsg-client/internal/proto"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// outboxFile is stored in user's history directory
+const outboxFile = "outbox.json"
+
+// queued is message waiting for peer to come online
+type queued struct {
+	Peer string    `json:"peer"`
+	ID   string    `json:"id"`
+	Raw  string    `json:"raw"` // text with envelope, as it's sent
+	Time time.Time `json:"time"`
+}
+
+// Outbox keeps messages to offline peers and sends them when peers come online
+type Outbox struct {
+	mu       sync.Mutex
+	items    []queued
+	flushing map[string]bool
+	loadedOf string // name of user whose outbox is loaded
+	// Sent is called when queued message is sent
+	Sent func(peer, id string)
+}
+
+var outbox = &Outbox{flushing: make(map[string]bool), Sent: func(string, string) {}}
+
+func outboxPath() string {
+	return filepath.Join(historyDir, store.Name(), outboxFile)
+}
+
+// load reads outbox if user changed; must be called with locked mu
+func (ob *Outbox) load() {
+	if ob.loadedOf == store.Name() {
+		return
+	}
+	ob.loadedOf, ob.items = store.Name(), nil
+	if !isSafeName(store.Name()) {
+		return
+	}
+	dat, err := ioutil.ReadFile(outboxPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			errl.Println(err)
+		}
+		return
+	}
+	if err := json.Unmarshal(dat, &ob.items); err != nil {
+		errl.Println(err)
+	}
+}
+
+// save writes outbox; must be called with locked mu
+func (ob *Outbox) save() error {
+	if !isSafeName(store.Name()) {
+		return errBadPeerName
+	}
+	dat, err := json.Marshal(ob.items)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(outboxPath()), 0700); err != nil {
+		return err
+	}
+	tmp := outboxPath() + ".tmp"
+	if err := ioutil.WriteFile(tmp, dat, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, outboxPath())
+}
+
+// Queue stores message until peer comes online
+func (ob *Outbox) Queue(peer string, g GUIMessage, env envelope) error {
+	raw, err := proto.Encode(g.Text, env)
+	if err != nil {
+		return err
+	}
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	ob.load()
+	ob.items = append(ob.items, queued{Peer: peer, ID: g.ID, Raw: raw, Time: g.Time})
+	return ob.save()
+}
+
+// Pending returns ids of messages waiting for peer
+func (ob *Outbox) Pending(peer string) map[string]bool {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	ob.load()
+	ids := make(map[string]bool)
+	for _, q := range ob.items {
+		if q.Peer == peer {
+			ids[q.ID] = true
+		}
+	}
+	return ids
+}
+
+// next returns first queued message to peer
+func (ob *Outbox) next(peer string) (queued, bool) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	ob.load()
+	for _, q := range ob.items {
+		if q.Peer == peer {
+			return q, true
+		}
+	}
+	return queued{}, false
+}
+
+// remove deletes message to peer; message to group is queued with same id for every member
+func (ob *Outbox) remove(peer, id string) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	for i, q := range ob.items {
+		if q.Peer == peer && q.ID == id {
+			ob.items = append(ob.items[:i], ob.items[i+1:]...)
+			break
+		}
+	}
+	if err := ob.save(); err != nil {
+		errl.Println(err)
+	}
+}
+
+// Flush sends messages to peer in order they were written; it stops at first error
+func (ob *Outbox) Flush(peer string) {
+	ob.mu.Lock()
+	if ob.flushing[peer] {
+		ob.mu.Unlock()
+		return
+	}
+	ob.flushing[peer] = true
+	ob.mu.Unlock()
+	defer func() {
+		ob.mu.Lock()
+		delete(ob.flushing, peer)
+		ob.mu.Unlock()
+	}()
+	for {
+		q, ok := ob.next(peer)
+		if !ok {
+			return
+		}
+		if err := sendMessage(store.Token(), q.Raw, peer); err != nil {
+			errl.Println(err)
+			return
+		}
+		ob.remove(peer, q.ID)
+		ob.Sent(peer, q.ID)
+	}
+}
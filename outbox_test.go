@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestOutboxGroupMessage checks that copies of group message queued for
+// several members are removed one by one
+func TestOutboxGroupMessage(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil { // outbox is written to working directory
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	errl = log.New(ioutil.Discard, "", 0)
+	store.SetAccount("me", "token")
+	ob := &Outbox{flushing: make(map[string]bool), Sent: func(string, string) {}}
+
+	env := newEnvelope(ctText)
+	g := GUIMessage{ID: env.ID, From: "me", Text: "hi all", Type: ctText, Time: time.Now()}
+	for _, member := range []string{"bob", "alice"} {
+		if err := ob.Queue(member, g, env); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	q, ok := ob.next("alice")
+	if !ok || q.Peer != "alice" || q.ID != g.ID {
+		t.Fatalf("next(alice) = %+v, %v", q, ok)
+	}
+	ob.remove("alice", q.ID)
+	if q, ok := ob.next("alice"); ok {
+		t.Errorf("message to alice is left after it's sent: %+v", q)
+	}
+	if !ob.Pending("bob")[g.ID] {
+		t.Fatal("message to bob is removed with message to alice")
+	}
+	ob.remove("bob", g.ID)
+	if q, ok := ob.next("bob"); ok {
+		t.Errorf("message to bob is left after it's sent: %+v", q)
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"gioui.org/f32"
+	"gioui.org/layout"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/unit"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// presencePollInterval is interval between checks of all peers
+	presencePollInterval = 20 * time.Second
+	// presenceMaxBackoff is max interval between checks when server doesn't answer
+	presenceMaxBackoff = 5 * time.Minute
+	// presenceWorkers is how many isOnline requests are done at once
+	presenceWorkers = 4
+	// lastSeenFile is stored in user's history directory
+	lastSeenFile = "last_seen.json"
+)
+
+// peerPresence is what is known about peer being online
+type peerPresence struct {
+	Online bool
+	// Known is false until peer was checked or it sent something
+	Known    bool
+	LastSeen time.Time
+	// watched is true if peer has chat, so it's polled
+	watched bool
+}
+
+// Presence keeps online statuses of peers and polls them
+type Presence struct {
+	mu       sync.Mutex
+	m        map[string]*peerPresence
+	loadedOf string // name of user whose last seen times are loaded
+	// Invalidate redraws window when status changes
+	Invalidate func()
+	// OnOnline is called in new goroutine when peer comes online
+	OnOnline func(peer string)
+}
+
+var presence = &Presence{
+	m:          make(map[string]*peerPresence),
+	Invalidate: func() {},
+	OnOnline:   func(string) {},
+}
+
+// presenceFrame is pushed by server when peer goes online or offline
+type presenceFrame struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Online bool   `json:"online"`
+}
+
+func lastSeenPath() string {
+	return filepath.Join(historyDir, store.Name(), lastSeenFile)
+}
+
+// loadLastSeen reads last seen times if user changed; must be called with locked mu
+func (p *Presence) loadLastSeen() {
+	if p.loadedOf == store.Name() {
+		return
+	}
+	p.loadedOf = store.Name()
+	p.m = make(map[string]*peerPresence)
+	if !isSafeName(store.Name()) {
+		return
+	}
+	dat, err := ioutil.ReadFile(lastSeenPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			errl.Println(err)
+		}
+		return
+	}
+	var seen map[string]time.Time
+	if err := json.Unmarshal(dat, &seen); err != nil {
+		errl.Println(err)
+		return
+	}
+	for peer, t := range seen {
+		p.m[peer] = &peerPresence{LastSeen: t}
+	}
+}
+
+// saveLastSeen writes last seen times; must be called with locked mu
+func (p *Presence) saveLastSeen() {
+	if !isSafeName(store.Name()) {
+		return
+	}
+	seen := make(map[string]time.Time, len(p.m))
+	for peer, pp := range p.m {
+		if !pp.LastSeen.IsZero() {
+			seen[peer] = pp.LastSeen
+		}
+	}
+	dat, err := json.Marshal(seen)
+	if err != nil {
+		errl.Println(err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(lastSeenPath()), 0700); err != nil {
+		errl.Println(err)
+		return
+	}
+	if err := ioutil.WriteFile(lastSeenPath(), dat, 0600); err != nil {
+		errl.Println(err)
+	}
+}
+
+// Watch adds peer to polled ones
+func (p *Presence) Watch(peer string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.loadLastSeen()
+	pp, ok := p.m[peer]
+	if !ok {
+		pp = new(peerPresence)
+		p.m[peer] = pp
+	}
+	pp.watched = true
+}
+
+// Save writes last seen times, e.g. before exit
+func (p *Presence) Save() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.loadedOf == store.Name() {
+		p.saveLastSeen()
+	}
+}
+
+// Get returns what is known about peer
+func (p *Presence) Get(peer string) peerPresence {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.loadLastSeen()
+	if pp, ok := p.m[peer]; ok {
+		return *pp
+	}
+	return peerPresence{}
+}
+
+// Set sets online status of peer; last seen time is saved when peer goes offline
+func (p *Presence) Set(peer string, online bool) {
+	p.mu.Lock()
+	p.loadLastSeen()
+	pp, ok := p.m[peer]
+	if !ok {
+		pp = new(peerPresence)
+		p.m[peer] = pp
+	}
+	changed := !pp.Known || pp.Online != online
+	if online || pp.Online {
+		pp.LastSeen = time.Now()
+	}
+	pp.Online, pp.Known = online, true
+	if changed && !online {
+		p.saveLastSeen()
+	}
+	p.mu.Unlock()
+	if changed {
+		p.Invalidate()
+		if online {
+			go p.OnOnline(peer)
+		}
+	}
+}
+
+// peers returns names of peers which have chat or are in contacts; other ones
+// are only remembered for their last seen time
+func (p *Presence) peers() []string {
+	p.mu.Lock()
+	p.loadLastSeen()
+	peers := make([]string, 0, len(p.m))
+	var rest []string
+	for peer, pp := range p.m {
+		if pp.watched {
+			peers = append(peers, peer)
+		} else {
+			rest = append(rest, peer)
+		}
+	}
+	p.mu.Unlock()
+	for _, peer := range rest {
+		if _, ok := contacts.Get(peer); ok {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// poll checks all peers, presenceWorkers at once. Returns false if all requests failed
+func (p *Presence) poll() bool {
+	peers := p.peers()
+	if len(peers) == 0 {
+		return true
+	}
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		fine bool
+		sem  = make(chan struct{}, presenceWorkers)
+	)
+	for _, peer := range peers {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(peer string) {
+			defer func() { <-sem; wg.Done() }()
+			is, _, err := isOnline(peer)
+			if err != nil {
+				errl.Println(err)
+				return
+			}
+			mu.Lock()
+			fine = true
+			mu.Unlock()
+			p.Set(peer, is)
+		}(peer)
+	}
+	wg.Wait()
+	return fine
+}
+
+// Run polls peers forever; interval grows while server doesn't answer
+func (p *Presence) Run() {
+	interval := presencePollInterval
+	for {
+		time.Sleep(interval)
+		if store.Token() == "" || store.HTTPURL() == "" {
+			continue
+		}
+		if p.poll() {
+			interval = presencePollInterval
+		} else if interval *= 2; interval > presenceMaxBackoff {
+			interval = presenceMaxBackoff
+		}
+	}
+}
+
+// IsOffline returns true only if peer is known to be offline
+func (p *Presence) IsOffline(peer string) bool {
+	pp := p.Get(peer)
+	return pp.Known && !pp.Online
+}
+
+// lastSeenStr returns human-readable status of peer
+func lastSeenStr(pp peerPresence) string {
+	switch {
+	case pp.Online:
+		return "online"
+	case pp.LastSeen.IsZero():
+		if pp.Known {
+			return "offline"
+		}
+		return ""
+	case pp.LastSeen.Format("02.01.2006") == time.Now().Format("02.01.2006"):
+		return "last seen at " + pp.LastSeen.Format("15:04")
+	}
+	return "last seen " + pp.LastSeen.Format("02.01.2006")
+}
+
+// layoutPresenceDot layouts green dot if peer is online, grey one if offline and nothing if it's unknown
+func layoutPresenceDot(gtx C, peer string) D {
+	pp := presence.Get(peer)
+	size := gtx.Px(unit.Dp(8))
+	if !pp.Known {
+		return D{Size: image.Pt(size, size)}
+	}
+	col := color.NRGBA{R: 128, G: 128, B: 128, A: 255}
+	if pp.Online {
+		col = color.NRGBA{R: 46, G: 160, B: 67, A: 255}
+	}
+	paint.FillShape(gtx.Ops, col, clip.UniformRRect(
+		f32.Rectangle{Max: layout.FPt(image.Pt(size, size))}, float32(size)/2,
+	).Op(gtx.Ops))
+	return D{Size: image.Pt(size, size)}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"time"
+)
+
+const (
+	ctReceipt = "receipt"
+	// receiptRetry is after how long failed read receipt is sent again
+	receiptRetry = 30 * time.Second
+	// kinds of receipts
+	receiptDelivered = "delivered"
+	receiptRead      = "read"
+)
+
+// rank of status; status of message is never changed to status with lower rank
+func (s msgStatus) rank() int {
+	switch s {
+	case msgSent:
+		return 1
+	case msgDelivered:
+		return 2
+	case msgRead:
+		return 3
+	}
+	return 0 // sending and failed
+}
+
+// sendReceipt tells peer that it's messages were delivered or read; it's called on UI goroutine.
+// Receipts are sent only to clients which understand envelopes and not to strangers
+// until user accepts their message request. Messages are marked as read only
+// when receipt is sent; failed read receipts are sent again after receiptRetry
+func sendReceipt(c *Chat, kind string, ids []string) {
+	if len(ids) == 0 || !c.Envelopes || c.Request {
+		return
+	}
+	if kind == receiptRead && conf.NoReadReceipts {
+		return
+	}
+	for len(ids) > 0 {
+		env, n := receiptEnvelope(kind, ids)
+		if n == 0 {
+			return // it's logged
+		}
+		batch := ids[:n:n]
+		ids = ids[n:]
+		if kind == receiptRead {
+			c.setReadSending(batch, true)
+		}
+		go func(peer string) {
+			err := sendEnvelope(store.Token(), "", peer, env)
+			if kind != receiptRead {
+				if err != nil {
+					errl.Println(err)
+				}
+				return
+			}
+			if err != nil {
+				errl.Println(err)
+				time.AfterFunc(receiptRetry, func() {
+					store.Do(func() { c.setReadSending(batch, false) })
+				})
+				return
+			}
+			if err := history.Mark(peer, batch, msgSent, true); err != nil { // it isn't sent again after restart
+				errl.Println(err)
+			}
+			store.Do(func() { c.markReadSent(batch) })
+		}(c.PeerName)
+	}
+}
+
+// receiptEnvelope returns envelope of receipt with as many first ids as fit in one message
+func receiptEnvelope(kind string, ids []string) (envelope, int) {
+	env := newEnvelope(ctReceipt)
+	if err := env.SetAttr("kind", kind); err != nil {
+		errl.Println(err)
+		return env, 0
+	}
+	for n := len(ids); n > 0; {
+		if err := env.SetAttr("ids", ids[:n]); err != nil {
+			errl.Println(err)
+			return env, 0
+		}
+		l := envelopeLen(env)
+		if l <= store.MaxMessageLen() {
+			return env, n
+		}
+		// ids have almost same length, so it's near to number which fits
+		if less := n * store.MaxMessageLen() / l; less > 0 && less < n {
+			n = less
+		} else {
+			n--
+		}
+	}
+	errl.Println("receipt with one id doesn't fit in message")
+	return env, 0
+}
+
+// setReadSending marks messages whose read receipt is being sent, so it isn't sent twice
+func (c *Chat) setReadSending(ids []string, sending bool) {
+	in := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		in[id] = true
+	}
+	for i := range c.Messages {
+		if in[c.Messages[i].ID] {
+			c.Messages[i].readSending = sending
+		}
+	}
+}
+
+// markReadSent remembers that read receipts of messages were sent
+func (c *Chat) markReadSent(ids []string) {
+	sent := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		sent[id] = true
+	}
+	for i := range c.Messages {
+		if sent[c.Messages[i].ID] {
+			c.Messages[i].ReadSent = true
+			c.Messages[i].readSending = false
+		}
+	}
+}
+
+// applyReceipt updates statuses of own messages from got receipt
+func applyReceipt(c *Chat, env *envelope) {
+	var (
+		kind string
+		ids  []string
+	)
+	if !env.Attr("kind", &kind) || !env.Attr("ids", &ids) {
+		return
+	}
+	st := msgDelivered
+	if kind == receiptRead {
+		st = msgRead
+	}
+	got := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		got[id] = true
+	}
+	var changed []string
+	for i := range c.Messages {
+		if m := &c.Messages[i]; got[m.ID] && m.From == store.Name() && st.rank() > m.Status.rank() {
+			m.Status = st
+			changed = append(changed, m.ID)
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+	go func(peer string) { // ticks are kept after restart
+		if err := history.Mark(peer, changed, st, false); err != nil {
+			errl.Println(err)
+		}
+	}(c.PeerName)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+)
+
+// excerptLen is max length of quoted part of message in replies
+const excerptLen = 50
+
+// stripQuote removes quote lines from start of message
+// (so replies to replies don't contain whole thread)
+func stripQuote(txt string) string {
+	lines := strings.Split(txt, "\n")
+	for len(lines) > 1 && strings.HasPrefix(lines[0], ">") {
+		lines = lines[1:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// excerpt returns short one-line version of message
+func excerpt(txt string) string {
+	t := []rune(strings.Join(strings.Fields(stripQuote(txt)), " "))
+	if len(t) > excerptLen {
+		return string(t[:excerptLen-3]) + "..."
+	}
+	return string(t)
+}
+
+// replyText adds quote of original message before text.
+// Quote is just markdown, so even old clients show what it is answer to
+func replyText(orig GUIMessage, txt string) string {
+	return "> " + orig.From + ": " + excerpt(orig.Text) + "\n" + txt
+}
+
+// quoteText makes markdown quote from text
+func quoteText(txt string) string {
+	lines := strings.Split(stripQuote(txt), "\n")
+	for i := range lines {
+		lines[i] = "> " + lines[i]
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
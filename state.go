@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net"
+	"sync"
+)
+
+// Store is state shared by UI and network goroutines.
+// Chats and their messages are changed only on UI goroutine: other goroutines
+// send changes with Do and they are applied between frames, so UI never sees
+// half-changed chat. Connection, server, account and config are guarded by mutex;
+// conf.Name and conf.Token are written only by SetAccount, other fields of conf
+// are changed only with Conf
+type Store struct {
+	// Chats are touched only on UI goroutine
+	Chats  []*Chat
+	events chan func()
+
+	mu        sync.RWMutex
+	conn      net.Conn
+	connected bool
+	httpURL   string
+	tcpURL    string
+	// maxLen is limit of message length in runes; it is taken
+	// from config and lowered if server tells it's own limit
+	maxLen int
+}
+
+var store = &Store{Chats: make([]*Chat, 0), events: make(chan func(), 256)}
+
+// Do sends change to UI goroutine. It blocks if UI is too slow, so
+// goroutine which gets messages can't eat all memory
+func (s *Store) Do(f func()) {
+	s.events <- f
+}
+
+// Events returns changes which must be applied on UI goroutine
+func (s *Store) Events() <-chan func() {
+	return s.events
+}
+
+// Conn returns connection to server (or nil)
+func (s *Store) Conn() net.Conn {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.conn
+}
+
+// SetConn sets connection to server
+func (s *Store) SetConn(conn net.Conn) {
+	s.mu.Lock()
+	s.conn, s.connected = conn, conn != nil
+	s.mu.Unlock()
+}
+
+// Connected returns true if client is connected to server
+func (s *Store) Connected() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.connected
+}
+
+// SetConnected sets connection status (connection is lost before it's replaced)
+func (s *Store) SetConnected(ok bool) {
+	s.mu.Lock()
+	s.connected = ok
+	s.mu.Unlock()
+}
+
+// Servers returns urls of http and tcp servers
+func (s *Store) Servers() (http, tcp string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.httpURL, s.tcpURL
+}
+
+// HTTPURL returns url of http server
+func (s *Store) HTTPURL() string {
+	http, _ := s.Servers()
+	return http
+}
+
+// SetServers sets urls of servers
+func (s *Store) SetServers(http, tcp string) {
+	s.mu.Lock()
+	s.httpURL, s.tcpURL = http, tcp
+	s.mu.Unlock()
+}
+
+// Name returns nick of user
+func (s *Store) Name() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return conf.Name
+}
+
+// Token returns token of user
+func (s *Store) Token() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return conf.Token
+}
+
+// Conf changes config; it must be used for all changes after start,
+// because config is read and saved by other goroutines
+func (s *Store) Conf(f func()) {
+	s.mu.Lock()
+	f()
+	s.mu.Unlock()
+}
+
+// ReadConf reads config, so it isn't changed meanwhile
+func (s *Store) ReadConf(f func()) {
+	s.mu.RLock()
+	f()
+	s.mu.RUnlock()
+}
+
+// MaxMessageLen returns limit of message length in runes
+func (s *Store) MaxMessageLen() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.maxLen
+}
+
+// SetMaxMessageLen sets limit of message length in runes
+func (s *Store) SetMaxMessageLen(n int) {
+	s.mu.Lock()
+	s.maxLen = n
+	s.mu.Unlock()
+}
+
+// SetAccount sets nick and token of user (empty ones if user logs out)
+func (s *Store) SetAccount(name, token string) {
+	s.mu.Lock()
+	conf.Name, conf.Token = name, token
+	s.mu.Unlock()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestStoreRace gets messages while UI goroutine applies them and config is changed;
+// it's useful with -race
+func TestStoreRace(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil { // history and config are written to working directory
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	errl = log.New(ioutil.Discard, "", 0)
+	store.SetAccount("me", "token")
+	store.SetMaxMessageLen(2048)
+	defer func() { store.Chats = []*Chat{} }()
+
+	stop := make(chan struct{})
+	uiDone := make(chan struct{})
+	go func() { // it's UI goroutine
+		defer close(uiDone)
+		for {
+			select {
+			case f := <-store.Events():
+				f()
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	ch := make(chan message)
+	getterDone := make(chan struct{})
+	go func() {
+		messageGetter(ch)
+		close(getterDone)
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			if err := block("spammer"); err != nil {
+				t.Error(err)
+			}
+			if err := unblock("spammer"); err != nil {
+				t.Error(err)
+			}
+			store.SetMaxMessageLen(1024 + i)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		from := "alice"
+		if i%2 == 0 {
+			from = "spammer"
+		}
+		ch <- message{Type: "message", From: from, Message: fmt.Sprintf("hi %d", i), got: time.Now()}
+	}
+	close(ch)
+	<-getterDone
+	wg.Wait()
+
+	var got int
+	store.Do(func() {
+		got = len(GetByPN(store.Chats, "alice").Messages)
+		close(stop)
+	})
+	<-uiDone
+	history.Flush()
+	if got == 0 {
+		t.Error("messages of alice weren't added")
+	}
+}
@@ -1,15 +1,16 @@
 package main
 
-type answer struct {
-	Success bool                   `json:"succes"`
-	Error   string                 `json:"error"`
-	Res     map[string]interface{} `json:"result"`
-}
+import (
+	"time"
+)
 
 type message struct {
+	Type    string `json:"type"`
 	From    string `json:"from_name"`
 	Message string `json:"message"`
 	Error   string `json:"error,omitempty"`
+	// got is when message was read from connection
+	got time.Time
 }
 
 type sendMessageReq struct {
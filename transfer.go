@@ -0,0 +1,575 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gioui.org/widget"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// content types of file transfer. Offer is shown in chat,
+// other ones are only control messages
+const (
+	ctFileOffer  = "file"
+	ctFileAnswer = "file_answer"
+	ctFileChunk  = "file_chunk"
+	ctFileResend = "file_resend"
+)
+
+const (
+	// chunkOverhead is place in message which is taken by envelope of chunk
+	chunkOverhead = 400
+	minChunkSize  = 256
+	// stallTimeout is after how long without chunks missing ones are asked again
+	stallTimeout = 10 * time.Second
+	// maxResendAsks is how many times missing chunks are asked before failing
+	maxResendAsks = 6
+	// maxResendIndexes is max number of chunks asked by one message
+	maxResendIndexes = 100
+	sendRetries      = 3
+)
+
+type transferState int
+
+const (
+	tsOffered transferState = iota
+	tsActive
+	tsDone
+	tsDeclined
+	tsFailed
+)
+
+// fileManifest describes file in offer
+type fileManifest struct {
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	ChunkSize int    `json:"chunk_size"`
+	Chunks    int    `json:"chunks"`
+	Sum       string `json:"sha256"`
+}
+
+// fileChunk is piece of file; json encodes Data as base64
+type fileChunk struct {
+	Transfer string `json:"transfer"`
+	Index    int    `json:"index"`
+	Data     []byte `json:"data"`
+	Sum      string `json:"sha256"`
+}
+
+// Transfer is file which is sent or got
+type Transfer struct {
+	ID         string // id of offer message
+	Peer       string
+	Outgoing   bool
+	Manifest   fileManifest
+	Path       string // file which is sent or where got file is saved
+	State      transferState
+	Done       int // number of sent or got chunks
+	Err        string
+	AcceptBtn  *widget.Clickable
+	DeclineBtn *widget.Clickable
+	// Trusted is true if file is from known peer, so it's image is shown at once
+	Trusted      bool
+	ShowImage    bool
+	ImageBtn     *widget.Clickable
+	LoadImageBtn *widget.Clickable
+	marks        []bool // sent or got chunks
+	part         *os.File
+	lastChunk    time.Time
+	asks         int
+}
+
+// Transfers is registry of transfers of this session
+type Transfers struct {
+	mu sync.Mutex
+	m  map[string]*Transfer
+	// Invalidate redraws window when progress changes
+	Invalidate func()
+}
+
+var transfers = &Transfers{m: make(map[string]*Transfer), Invalidate: func() {}}
+
+// Snapshot returns copy of transfer which is safe to read
+func (ts *Transfers) Snapshot(id string) (Transfer, bool) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	t, ok := ts.m[id]
+	if !ok {
+		return Transfer{}, false
+	}
+	return *t, true
+}
+
+// chunkSize returns size of chunk which fits in one message
+func chunkSize() int {
+	if n := (store.MaxMessageLen() - chunkOverhead) * 3 / 4; n > minChunkSize {
+		return n
+	}
+	return minChunkSize
+}
+
+func humanSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+func fileSum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Offer offers file to peer of chat and adds offer to chat
+func (ts *Transfers) Offer(c *Chat, path string) error {
+	st, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if st.IsDir() {
+		return errors.New("it's directory")
+	}
+	if st.Size() > conf.MaxFileSize {
+		return fmt.Errorf("file is bigger than %s", humanSize(conf.MaxFileSize))
+	}
+	sum, err := fileSum(path)
+	if err != nil {
+		return err
+	}
+	man := fileManifest{
+		Name:      filepath.Base(path),
+		Size:      st.Size(),
+		ChunkSize: chunkSize(),
+		Sum:       sum,
+	}
+	man.Chunks = int((man.Size + int64(man.ChunkSize) - 1) / int64(man.ChunkSize))
+	env := newEnvelope(ctFileOffer)
+	if err := env.SetAttr("file", man); err != nil {
+		return err
+	}
+	txt := fmt.Sprintf("[file] %s (%s)", man.Name, humanSize(man.Size))
+	if err := sendEnvelope(store.Token(), txt, c.PeerName, env); err != nil {
+		return err
+	}
+	ts.mu.Lock()
+	ts.m[env.ID] = &Transfer{
+		ID:           env.ID,
+		Peer:         c.PeerName,
+		Outgoing:     true,
+		Manifest:     man,
+		Path:         path,
+		Trusted:      true,
+		ImageBtn:     new(widget.Clickable),
+		LoadImageBtn: new(widget.Clickable),
+		marks:        make([]bool, man.Chunks),
+	}
+	ts.mu.Unlock()
+	g := GUIMessage{ID: env.ID, From: store.Name(), Text: txt, Type: ctFileOffer, Time: time.Now()}
+	store.Do(func() { c.AddMessage(g) })
+	if err := history.Append(c.PeerName, g); err != nil {
+		errl.Println(err)
+	}
+	return nil
+}
+
+// HandleOffer registers got offer; too big files are declined at once.
+// Manifest is written by peer, so nothing is allocated for it before it's accepted
+func (ts *Transfers) HandleOffer(c *Chat, g GUIMessage, env *envelope) {
+	var man fileManifest
+	if !env.Attr("file", &man) || man.Size < 0 {
+		return
+	}
+	tooBig := man.Size > conf.MaxFileSize
+	// chunk is sent in one message, so it can't be bigger than message
+	if !tooBig && (man.ChunkSize < minChunkSize || man.ChunkSize > store.MaxMessageLen() || man.Chunks < 0 ||
+		int64(man.Chunks) != (man.Size+int64(man.ChunkSize)-1)/int64(man.ChunkSize)) {
+		return
+	}
+	t := &Transfer{
+		ID:           g.ID,
+		Peer:         c.PeerName,
+		Manifest:     man,
+		AcceptBtn:    new(widget.Clickable),
+		DeclineBtn:   new(widget.Clickable),
+		Trusted:      c.IsKnown(),
+		ImageBtn:     new(widget.Clickable),
+		LoadImageBtn: new(widget.Clickable),
+	}
+	if tooBig {
+		t.Err = "file is too big"
+	}
+	ts.mu.Lock()
+	if _, ok := ts.m[t.ID]; ok {
+		ts.mu.Unlock()
+		return
+	}
+	ts.m[t.ID] = t
+	ts.mu.Unlock()
+	if tooBig {
+		ts.Decline(t.ID)
+	}
+}
+
+func sendAnswer(peer, id string, accept bool) error {
+	env := newEnvelope(ctFileAnswer)
+	if err := env.SetAttr("transfer", id); err != nil {
+		return err
+	}
+	if err := env.SetAttr("accept", accept); err != nil {
+		return err
+	}
+	return sendEnvelope(store.Token(), "", peer, env)
+}
+
+// Accept accepts incoming transfer and starts waiting for chunks
+func (ts *Transfers) Accept(id string) {
+	ts.mu.Lock()
+	t, ok := ts.m[id]
+	if !ok || t.Outgoing || t.State != tsOffered {
+		ts.mu.Unlock()
+		return
+	}
+	err := os.MkdirAll(conf.DownloadDir, 0700)
+	if err == nil {
+		t.part, err = os.OpenFile(filepath.Join(conf.DownloadDir, t.ID+".part"), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0600)
+	}
+	if err != nil {
+		errl.Println(err)
+		t.State, t.Err = tsFailed, "can't create file"
+		ts.mu.Unlock()
+		return
+	}
+	t.State, t.lastChunk = tsActive, time.Now()
+	t.marks = make([]bool, t.Manifest.Chunks)
+	peer, empty := t.Peer, t.Manifest.Chunks == 0
+	ts.mu.Unlock()
+	go func() {
+		if err := sendAnswer(peer, id, true); err != nil {
+			errl.Println(err)
+			ts.fail(id, "can't answer")
+			return
+		}
+		if empty {
+			ts.finish(id)
+			return
+		}
+		ts.watch(id)
+	}()
+}
+
+// Decline declines incoming transfer
+func (ts *Transfers) Decline(id string) {
+	ts.mu.Lock()
+	t, ok := ts.m[id]
+	if !ok || t.Outgoing || t.State != tsOffered {
+		ts.mu.Unlock()
+		return
+	}
+	t.State = tsDeclined
+	peer := t.Peer
+	ts.mu.Unlock()
+	go func() {
+		if err := sendAnswer(peer, id, false); err != nil {
+			errl.Println(err)
+		}
+	}()
+	ts.Invalidate()
+}
+
+// ShowImage allows to show image from unknown peer
+func (ts *Transfers) ShowImage(id string) {
+	ts.mu.Lock()
+	if t, ok := ts.m[id]; ok {
+		t.ShowImage = true
+	}
+	ts.mu.Unlock()
+}
+
+func (ts *Transfers) fail(id, reason string) {
+	ts.mu.Lock()
+	if t, ok := ts.m[id]; ok && t.State != tsDone {
+		t.State, t.Err = tsFailed, reason
+		if t.part != nil {
+			t.part.Close()
+			os.Remove(t.part.Name())
+			t.part = nil
+		}
+	}
+	ts.mu.Unlock()
+	ts.Invalidate()
+}
+
+// HandleControl handles answers, chunks and resend requests; it's called on goroutine which gets messages
+func (ts *Transfers) HandleControl(peer, typ string, env *envelope) {
+	switch typ {
+	case ctFileAnswer:
+		var (
+			id     string
+			accept bool
+		)
+		if !env.Attr("transfer", &id) || !env.Attr("accept", &accept) {
+			return
+		}
+		ts.mu.Lock()
+		t, ok := ts.m[id]
+		if !ok || !t.Outgoing || t.Peer != peer || t.State != tsOffered {
+			ts.mu.Unlock()
+			return
+		}
+		if !accept {
+			t.State = tsDeclined
+			ts.mu.Unlock()
+			ts.Invalidate()
+			return
+		}
+		t.State = tsActive
+		all := make([]int, t.Manifest.Chunks)
+		for i := range all {
+			all[i] = i
+		}
+		ts.mu.Unlock()
+		go ts.sendChunks(id, all)
+	case ctFileResend:
+		var (
+			id      string
+			missing []int
+		)
+		if !env.Attr("transfer", &id) || !env.Attr("missing", &missing) {
+			return
+		}
+		ts.mu.Lock()
+		t, ok := ts.m[id]
+		if !ok || !t.Outgoing || t.Peer != peer || (t.State != tsActive && t.State != tsDone) {
+			ts.mu.Unlock()
+			return
+		}
+		ts.mu.Unlock()
+		go ts.sendChunks(id, missing)
+	case ctFileChunk:
+		var ch fileChunk
+		if !env.Attr("chunk", &ch) {
+			return
+		}
+		ts.gotChunk(peer, ch)
+	}
+}
+
+// sendChunks sends chunks of outgoing transfer
+func (ts *Transfers) sendChunks(id string, indexes []int) {
+	ts.mu.Lock()
+	t, ok := ts.m[id]
+	if !ok {
+		ts.mu.Unlock()
+		return
+	}
+	path, peer, man := t.Path, t.Peer, t.Manifest
+	ts.mu.Unlock()
+	f, err := os.Open(path)
+	if err != nil {
+		errl.Println(err)
+		ts.fail(id, "can't read file")
+		return
+	}
+	defer f.Close()
+	buf := make([]byte, man.ChunkSize)
+	for _, i := range indexes {
+		if i < 0 || i >= man.Chunks {
+			continue
+		}
+		n, err := f.ReadAt(buf, int64(i)*int64(man.ChunkSize))
+		if err != nil && err != io.EOF {
+			errl.Println(err)
+			ts.fail(id, "can't read file")
+			return
+		}
+		sum := sha256.Sum256(buf[:n])
+		env := newEnvelope(ctFileChunk)
+		if err := env.SetAttr("chunk", fileChunk{id, i, buf[:n], hex.EncodeToString(sum[:])}); err != nil {
+			errl.Println(err)
+			ts.fail(id, "can't send chunk")
+			return
+		}
+		for try := 0; ; try++ {
+			err = sendEnvelope(store.Token(), "", peer, env)
+			if err == nil || try == sendRetries {
+				break
+			}
+			time.Sleep(time.Second << try)
+		}
+		if err != nil {
+			errl.Println(err)
+			ts.fail(id, "can't send chunk")
+			return
+		}
+		ts.mu.Lock()
+		if !t.marks[i] {
+			t.marks[i] = true
+			t.Done++
+		}
+		if t.Done == man.Chunks {
+			t.State = tsDone
+		}
+		ts.mu.Unlock()
+		ts.Invalidate()
+	}
+}
+
+// gotChunk checks and writes got chunk
+func (ts *Transfers) gotChunk(peer string, ch fileChunk) {
+	sum := sha256.Sum256(ch.Data)
+	ts.mu.Lock()
+	t, ok := ts.m[ch.Transfer]
+	if !ok || t.Outgoing || t.Peer != peer || t.State != tsActive ||
+		ch.Index < 0 || ch.Index >= t.Manifest.Chunks || t.marks[ch.Index] {
+		ts.mu.Unlock()
+		return
+	}
+	man := t.Manifest
+	wantLen := man.ChunkSize
+	if ch.Index == man.Chunks-1 {
+		wantLen = int(man.Size - int64(man.ChunkSize)*int64(man.Chunks-1))
+	}
+	// broken chunk is just skipped: it'll be asked again as missing
+	if hex.EncodeToString(sum[:]) != ch.Sum || len(ch.Data) != wantLen {
+		ts.mu.Unlock()
+		return
+	}
+	if _, err := t.part.WriteAt(ch.Data, int64(ch.Index)*int64(man.ChunkSize)); err != nil {
+		ts.mu.Unlock()
+		errl.Println(err)
+		ts.fail(t.ID, "can't write file")
+		return
+	}
+	t.marks[ch.Index] = true
+	t.Done++
+	t.lastChunk, t.asks = time.Now(), 0
+	done := t.Done == man.Chunks
+	ts.mu.Unlock()
+	if done {
+		ts.finish(t.ID)
+	}
+	ts.Invalidate()
+}
+
+// watch asks missing chunks of incoming transfer when they stop coming
+func (ts *Transfers) watch(id string) {
+	tick := time.NewTicker(stallTimeout / 2)
+	defer tick.Stop()
+	for range tick.C {
+		ts.mu.Lock()
+		t, ok := ts.m[id]
+		if !ok || t.State != tsActive {
+			ts.mu.Unlock()
+			return
+		}
+		if time.Since(t.lastChunk) < stallTimeout {
+			ts.mu.Unlock()
+			continue
+		}
+		if t.asks >= maxResendAsks {
+			ts.mu.Unlock()
+			ts.fail(id, "peer stopped sending")
+			return
+		}
+		t.asks++
+		t.lastChunk = time.Now()
+		var missing []int
+		for i, got := range t.marks {
+			if !got {
+				missing = append(missing, i)
+				if len(missing) == maxResendIndexes {
+					break
+				}
+			}
+		}
+		peer := t.Peer
+		ts.mu.Unlock()
+		env := newEnvelope(ctFileResend)
+		if err := env.SetAttr("transfer", id); err != nil {
+			errl.Println(err)
+			continue
+		}
+		if err := env.SetAttr("missing", missing); err != nil {
+			errl.Println(err)
+			continue
+		}
+		if err := sendEnvelope(store.Token(), "", peer, env); err != nil {
+			errl.Println(err)
+		}
+	}
+}
+
+// finish checks got file and moves it to download directory
+func (ts *Transfers) finish(id string) {
+	ts.mu.Lock()
+	t, ok := ts.m[id]
+	if !ok || t.part == nil {
+		ts.mu.Unlock()
+		return
+	}
+	part := t.part
+	t.part = nil
+	man := t.Manifest
+	ts.mu.Unlock()
+	partName := part.Name()
+	if err := part.Close(); err != nil {
+		errl.Println(err)
+		ts.fail(id, "can't write file")
+		return
+	}
+	sum, err := fileSum(partName)
+	if err != nil || sum != man.Sum {
+		if err != nil {
+			errl.Println(err)
+		}
+		os.Remove(partName)
+		ts.fail(id, "file is broken")
+		return
+	}
+	path := freeFileName(conf.DownloadDir, man.Name)
+	if err := os.Rename(partName, path); err != nil {
+		errl.Println(err)
+		ts.fail(id, "can't save file")
+		return
+	}
+	ts.mu.Lock()
+	t.State, t.Path = tsDone, path
+	ts.mu.Unlock()
+	ts.Invalidate()
+}
+
+// freeFileName returns path in dir which isn't taken; name is cleaned
+// because it's got from peer
+func freeFileName(dir, name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == ".." || name == "/" || name == "" {
+		name = "file"
+	}
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	path := filepath.Join(dir, name)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
+		}
+		path = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", base, i, ext))
+	}
+}
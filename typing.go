@@ -0,0 +1,38 @@
+package main
+
+import (
+	"time"
+)
+
+const (
+	ctTyping = "typing"
+	// typingTimeout is how long "typing" is shown after notification
+	typingTimeout = 6 * time.Second
+	// typingThrottle is min interval between sent notifications to one peer
+	typingThrottle = 3 * time.Second
+)
+
+// SetTyping marks that peer is typing now
+func (c *Chat) SetTyping() {
+	c.TypingUntil = time.Now().Add(typingTimeout)
+}
+
+// IsTyping returns true if peer is typing and time when it must be hidden
+func (c *Chat) IsTyping() (bool, time.Time) {
+	return time.Now().Before(c.TypingUntil), c.TypingUntil
+}
+
+// NotifyTyping sends typing notification to peer if it's time to.
+// Notification is envelope without text, so it's sent only to peers
+// whose clients understand envelopes (old ones would show empty messages)
+func (c *Chat) NotifyTyping() {
+	if conf.NoTypingNotifs || !c.Envelopes || time.Since(c.lastTypingSent) < typingThrottle {
+		return
+	}
+	c.lastTypingSent = time.Now()
+	go func(peer string) {
+		if err := sendEnvelope(store.Token(), "", peer, newEnvelope(ctTyping)); err != nil {
+			errl.Println(err)
+		}
+	}(c.PeerName)
+}
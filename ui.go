@@ -2,17 +2,22 @@ package main
 
 import (
 	"context"
-	"errors"
+	"fmt"
 	"gioui.org/app"
+	"gioui.org/f32"
 	"gioui.org/font/gofont"
+	"gioui.org/io/clipboard"
+	"gioui.org/io/pointer"
 	"gioui.org/io/system"
 	"gioui.org/layout"
 	"gioui.org/op"
+	"gioui.org/op/clip"
 	"gioui.org/op/paint"
 	"gioui.org/text"
 	"gioui.org/unit"
 	"gioui.org/widget"
 	"gioui.org/widget/material"
+	"github.com/dikey0ficial/overmsg-client/internal/proto"
 	"github.com/sqweek/dialog"
 	"golang.org/x/exp/shiny/materialdesign/icons"
 	"image"
@@ -35,8 +40,10 @@ var (
 	stdDP   = unit.Dp(10)
 	hspacer = layout.Rigid(layout.Spacer{Height: stdDP}.Layout)
 	wspacer = layout.Rigid(layout.Spacer{Width: stdDP}.Layout)
-	messCh  = make(chan message, 0)
-	errSAW  = errors.New("started another work()")
+	messCh  = make(chan message, recvQueueSize)
+	errSAW  = errAlreadyRunning
+	// maxInputHeight is height after which message input stops growing
+	maxInputHeight = unit.Dp(120)
 )
 
 // UI _
@@ -52,10 +59,18 @@ type UI struct {
 // NewUI is constructor for UI
 func NewUI() *UI {
 	ui := new(UI)
+	ui.sawCh = make(chan struct{}, 1)
 	ui.SetTheme(conf.IsDark)
 	ui.ChatList = new(ChatList)
 	ui.ChatAct = new(ChatActivity)
-	ui.ChatList.Chats = make([]*Chat, 0)
+	if conf.Name != "" {
+		store.Chats = savedChats()
+	}
+	ui.ChatAct.Contact = newContactEditor(ui.Theme)
+	ui.ChatAct.Group = newGroupEditor(ui.Theme)
+	ui.ChatAct.AcceptBtn = material.Button(ui.Theme, new(widget.Clickable), "Accept")
+	ui.ChatAct.BlockBtn = material.Button(ui.Theme, new(widget.Clickable), "Block")
+	ui.ChatList.RequestsBtn = material.Button(ui.Theme, new(widget.Clickable), "")
 	ui.ChatList.List = &layout.List{Axis: layout.Vertical}
 	ui.ChatAct.List = &widget.List{List: layout.List{Axis: layout.Vertical, ScrollToEnd: true}}
 	ui.ChatAct.SendBtn = material.IconButton(
@@ -65,14 +80,21 @@ func NewUI() *UI {
 		"Send message",
 	)
 	ui.ChatAct.SendBtn.Size = unit.Dp(15)
+	ui.ChatAct.AttachBtn = material.IconButton(
+		ui.Theme,
+		new(widget.Clickable),
+		getIcon(icons.EditorAttachFile),
+		"Send file",
+	)
+	ui.ChatAct.AttachBtn.Size = unit.Dp(15)
 	ui.ChatAct.Input = material.Editor(
 		ui.Theme,
 		&widget.Editor{
-			SingleLine: true,
-			Submit:     true,
+			Submit: true, // shift+enter still inserts newline
 		},
 		"Type your message here...",
 	)
+	ui.ChatAct.CancelReplyBtn = new(widget.Clickable)
 	ui.ChatAct.NChat = new(NewChatAct)
 	ui.ChatAct.NChat.NickInput = material.Editor(
 		ui.Theme,
@@ -84,6 +106,19 @@ func NewUI() *UI {
 	)
 	ui.ChatAct.NChat.AcceptBtn = material.Button(ui.Theme, new(widget.Clickable), "Accept")
 	ui.ChatAct.NChat.CancelBtn = material.Button(ui.Theme, new(widget.Clickable), "Cancel")
+	ui.ChatAct.NChat.GroupName = material.Editor(
+		ui.Theme,
+		&widget.Editor{SingleLine: true},
+		"Name of group",
+	)
+	ui.ChatAct.NChat.GroupMembers = material.Editor(
+		ui.Theme,
+		&widget.Editor{SingleLine: true, Submit: true},
+		"Nicks of members, separated by spaces",
+	)
+	ui.ChatAct.NChat.CreateGroupBtn = material.Button(ui.Theme, new(widget.Clickable), "Create group")
+	ui.ChatAct.NChat.BroadcastBtn = material.Button(ui.Theme, new(widget.Clickable), "Broadcast")
+	ui.ChatAct.Broadcast = newBroadcastAct(ui.Theme)
 	ui.ChatList.PlusBtn = material.Button(
 		ui.Theme,
 		new(widget.Clickable),
@@ -102,6 +137,34 @@ func NewUI() *UI {
 		},
 		"Dark theme",
 	)
+	ui.ChatList.HomeTab.RawSwitch = material.Switch(
+		ui.Theme,
+		&widget.Bool{
+			Value: conf.RawText,
+		},
+		"Show raw text",
+	)
+	ui.ChatList.HomeTab.TypingSwitch = material.Switch(
+		ui.Theme,
+		&widget.Bool{
+			Value: !conf.NoTypingNotifs,
+		},
+		"Send typing notifications",
+	)
+	ui.ChatList.HomeTab.ReceiptsSwitch = material.Switch(
+		ui.Theme,
+		&widget.Bool{
+			Value: !conf.NoReadReceipts,
+		},
+		"Send read receipts",
+	)
+	ui.ChatList.HomeTab.ImagesSwitch = material.Switch(
+		ui.Theme,
+		&widget.Bool{
+			Value: conf.ImagesOnlyFromKnown,
+		},
+		"Images only from known peers",
+	)
 	ui.ChatList.HomeTab.NameInput = material.Editor(
 		ui.Theme,
 		&widget.Editor{
@@ -130,6 +193,8 @@ func NewUI() *UI {
 	ui.ChatList.HomeTab.AuthBtn = material.Button(ui.Theme, new(widget.Clickable), "Log in")    // я уже смешарик
 	ui.ChatList.HomeTab.LogoutBtn = material.Button(ui.Theme, new(widget.Clickable), "Log out") // я преисполниился в познании и больше не смешарие
 	ui.ChatList.HomeTab.PingBtn = material.Button(ui.Theme, new(widget.Clickable), "Ping")
+	ui.ChatList.HomeTab.ImportBtn = material.Button(ui.Theme, new(widget.Clickable), "Import contacts")
+	ui.ChatList.HomeTab.ExportBtn = material.Button(ui.Theme, new(widget.Clickable), "Export contacts")
 	if conf.Name == "" {
 		ui.ChatList.HomeTab.Settings.Value = true
 	}
@@ -151,7 +216,40 @@ func (ui *UI) SetTheme(dark bool) {
 func (ui *UI) Run(w *app.Window) error {
 	ui.Win = w
 	ui.ChatList.Invalidate, ui.ChatAct.NChat.Invalidate = ui.Win.Invalidate, ui.Win.Invalidate
-	go messageGetter(messCh, &ui.ChatList.Chats, ui.Win.Invalidate)
+	inv := throttle(ui.Win.Invalidate, maxFPS)
+	transfers.Invalidate, thumbs.Invalidate, viewer.Invalidate = inv, inv, inv
+	ui.ChatAct.Broadcast.Invalidate = inv
+	presence.Invalidate = inv
+	presence.OnOnline = outbox.Flush
+	outbox.Sent = func(peer, id string) {
+		store.Do(func() { GetByPN(store.Chats, peer).SetStatus(id, msgSent) })
+	}
+	go presence.Run()
+	go messageGetter(messCh)
+	ipc.Focus = w.Raise
+	ipc.Open = func(nick string) error { return openChat(ui, nick) }
+	go func() {
+		err := ipc.Serve()
+		if err == errAlreadyRunning { // it was started at the same time as we
+			ui.sawCh <- struct{}{}
+		} else if err != nil {
+			errl.Println(err)
+		}
+	}()
+	if conf.IRCAddr != "" {
+		go func() {
+			if err := serveIRC(conf.IRCAddr); err != nil {
+				errl.Println(err)
+			}
+		}()
+	}
+	if nick := openArg(os.Args[1:]); nick != "" && store.Token() != "" {
+		go func() {
+			if err := openChat(ui, nick); err != nil {
+				errl.Println(err)
+			}
+		}()
+	}
 	var ops op.Ops
 	for {
 		select {
@@ -170,12 +268,15 @@ func (ui *UI) Run(w *app.Window) error {
 				}
 				ui.Size = e.Size
 				ui.Layout(gtx)
-				ui.ChatAct.Chat = GetByPN(ui.ChatList.Chats, ui.ChatList.Selected)
+				ui.ChatAct.Chat = GetByPN(store.Chats, ui.ChatList.Selected)
 				ui.ChatAct.Selected = ui.ChatList.Selected
 				e.Frame(gtx.Ops)
 			case system.DestroyEvent:
 				return e.Err
 			}
+		case f := <-store.Events():
+			f()
+			inv() // flood of changes mustn't cause flood of redraws
 		case <-ui.sawCh:
 			return errSAW
 		}
@@ -228,10 +329,12 @@ type ChatList struct {
 	Invalidate func()
 	MaxX       int
 	Selected   string
-	Chats      []*Chat
 	HomeTab    *HomeTab
 	List       *layout.List
 	PlusBtn    material.ButtonStyle
+	// RequestsBtn shows or hides chats started by strangers
+	RequestsBtn  material.ButtonStyle
+	ShowRequests bool
 }
 
 // Layout _
@@ -248,15 +351,34 @@ func (cl *ChatList) Layout(gtx C, th T) D {
 				gx := *(&gtx)
 				gx.Constraints.Max.Y -= 45
 				gx.Constraints.Min.Y = gx.Constraints.Max.Y
-				return cl.List.Layout(gx, len(cl.Chats)+2, func(gtx C, ind int) D {
+				var chats, requests []*Chat
+				for _, c := range store.Chats {
+					if c.Request {
+						requests = append(requests, c)
+					} else {
+						chats = append(chats, c)
+					}
+				}
+				n := len(chats) + 2
+				if len(requests) != 0 {
+					n++
+					if cl.ShowRequests {
+						n += len(requests)
+					}
+				}
+				return cl.List.Layout(gx, n, func(gtx C, ind int) D {
 					return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
 						layout.Rigid(func(gtx C) D {
 							if ind == 0 {
 								return cl.HomeTab.LayoutList(gtx, th, cl)
 							} else if ind == 1 {
 								return D{}
+							} else if ind-2 < len(chats) {
+								return chats[ind-2].LayoutList(gtx, th, cl)
+							} else if ind-2 == len(chats) {
+								return cl.layoutRequestsBtn(gtx, len(requests))
 							}
-							return cl.Chats[ind-2].LayoutList(gtx, th, cl)
+							return requests[ind-3-len(chats)].LayoutList(gtx, th, cl)
 						}),
 						layout.Rigid(layout.Spacer{Height: unit.Dp(10)}.Layout),
 					)
@@ -275,6 +397,20 @@ func (cl *ChatList) Layout(gtx C, th T) D {
 	)
 }
 
+// layoutRequestsBtn layouts button which shows message requests
+func (cl *ChatList) layoutRequestsBtn(gtx C, n int) D {
+	if cl.RequestsBtn.Button.Clicked() {
+		cl.ShowRequests = !cl.ShowRequests
+	}
+	cl.RequestsBtn.Text = fmt.Sprintf("Message requests (%d) ▸", n)
+	if cl.ShowRequests {
+		cl.RequestsBtn.Text = fmt.Sprintf("Message requests (%d) ▾", n)
+	}
+	gtx.Constraints.Min.X = cl.MaxX/4 + 5
+	gtx.Constraints.Max.X = gtx.Constraints.Min.X
+	return cl.RequestsBtn.Layout(gtx)
+}
+
 // ChatActivity _
 type ChatActivity struct {
 	MaxX     int
@@ -285,6 +421,87 @@ type ChatActivity struct {
 	HomeTab  *HomeTab
 	NChat    *NewChatAct
 	Chat     *Chat
+	// AttachBtn sends file
+	AttachBtn material.IconButtonStyle
+	// ReplyTo is copy of message user answers to
+	ReplyTo        *GUIMessage
+	CancelReplyBtn *widget.Clickable
+	// Contact is form for editing of peer's contact
+	Contact *ContactEditor
+	// Group is form for editing of group
+	Group *GroupEditor
+	// Broadcast sends one message to several contacts
+	Broadcast *BroadcastAct
+	// cmdBtns are items of autocompletion of commands
+	cmdBtns map[string]*widget.Clickable
+	// AcceptBtn accepts message request; BlockBtn blocks or unblocks peer
+	AcceptBtn material.ButtonStyle
+	BlockBtn  material.ButtonStyle
+}
+
+// toggleBlock blocks or unblocks peer of current chat
+func (ca *ChatActivity) toggleBlock() {
+	peer := ca.Chat.PeerName
+	var err error
+	if isBlocked(peer) {
+		err = unblock(peer)
+		// messages which came while peer was blocked are in history
+		ca.Chat.Messages, ca.Chat.HasOlder = nil, false
+		go ca.Chat.loadHistory()
+	} else if dialog.Message("Block %s? Messages from them won't be shown, "+
+		"but they will be kept in history until you unblock them", peer).Title("Block").YesNo() {
+		err = block(peer)
+		ca.Chat.Request = false
+	}
+	if err != nil {
+		errl.Println(err)
+		dialog.Message("Error saving configuration").Title("Error!!1").Error()
+	}
+}
+
+// handleMsgActions does what user chose in messages' menus
+func (ca *ChatActivity) handleMsgActions() {
+	for i := 0; i < len(ca.Chat.Messages); i++ {
+		g := &ca.Chat.Messages[i]
+		if g.W == nil || g.W.Action == actNone {
+			continue
+		}
+		act := g.W.Action
+		g.W.Action = actNone
+		switch act {
+		case actReply:
+			r := GUIMessage{ID: g.ID, From: g.From, Text: g.Text}
+			ca.ReplyTo = &r
+			ca.Input.Editor.Focus()
+		case actQuote:
+			ca.Input.Editor.Insert(quoteText(g.Text))
+			ca.Input.Editor.Focus()
+		case actDelete:
+			if dialog.Message("Delete this message from your history?").Title("Delete").YesNo() {
+				c, id := ca.Chat, g.ID
+				go func() { // history file is rewritten, so it's done in background
+					history.Flush() // message may be not stored yet
+					if err := history.Delete(c.PeerName, id); err != nil {
+						errl.Println(err)
+						dialog.Message("Error deleting message from history").Title("Error!!1").Error()
+						return
+					}
+					store.Do(func() {
+						for i := range c.Messages {
+							if c.Messages[i].ID == id {
+								c.Messages = append(c.Messages[:i], c.Messages[i+1:]...)
+								return
+							}
+						}
+					})
+				}()
+			}
+		case actBlock:
+			ca.toggleBlock()
+		case actShow:
+			ca.Chat.ShowSuppressed(g.ID)
+		}
+	}
 }
 
 // Layout _
@@ -307,18 +524,43 @@ func (ca *ChatActivity) Layout(gtx C, th T, startX int, ui *UI) D {
 									return "Start page"
 								} else if ca.Selected == "_new_chat" {
 									return "New chat"
+								} else if ca.Selected == "_broadcast" {
+									return "Broadcast"
+								}
+								title := contacts.DisplayName(ca.Selected)
+								if ca.Chat != nil && ca.Chat.PeerName == ca.Selected {
+									title = ca.Chat.Title()
+									if is, until := ca.Chat.IsTyping(); is {
+										op.InvalidateOp{At: until}.Add(gtx.Ops) // to hide it in time
+										return "Chat with " + title + " (" + title + " is typing…)"
+									}
 								}
-								return "Chat with " + ca.Selected
+								if st := lastSeenStr(presence.Get(ca.Selected)); st != "" {
+									return "Chat with " + title + " (" + st + ")"
+								}
+								return "Chat with " + title
 							}()
-							return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+							isChat := !strings.HasPrefix(ca.Selected, "_")
+							dot := D{}
+							return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+								layout.Rigid(func(gtx C) D {
+									if !isChat {
+										return D{}
+									}
+									dot = layoutPresenceDot(gtx, ca.Selected)
+									dot.Size.X += gtx.Px(unit.Dp(5))
+									return dot
+								}),
 								layout.Rigid(material.Body2(th, s).Layout),
-								layout.Rigid(layout.Spacer{Width: unit.Px(
-									float32(ca.MaxX)/1.07 - // hello, hardcoded number! (got it during experiments)
-										float32(
-											// 20 because it is sum of spacer and insets (5 + 10 + 5)
-											20+startX+material.Body2(th, s).Layout(fgtx(gtx)).Size.X,
-										),
-								)}.Layout),
+								layout.Rigid(func(gtx C) D {
+									return layout.Spacer{Width: unit.Px(
+										float32(ca.MaxX)/1.07 - // hello, hardcoded number! (got it during experiments)
+											float32(
+												// 20 because it is sum of spacer and insets (5 + 10 + 5)
+												20+startX+dot.Size.X+material.Body2(th, s).Layout(fgtx(gtx)).Size.X,
+											),
+									)}.Layout(gtx)
+								}),
 							)
 						},
 					)
@@ -326,74 +568,254 @@ func (ca *ChatActivity) Layout(gtx C, th T, startX int, ui *UI) D {
 			)
 		}),
 		// messages
-		layout.Rigid(func(gtx C) D {
+		layout.Flexed(1, func(gtx C) D {
 			if ca.Selected == "_home" {
 				return ca.HomeTab.Layout(gtx, th, ui)
 			} else if ca.Selected == "_new_chat" {
-				return ca.NChat.Layout(gtx, th, &ui.ChatList.Selected, &ui.ChatList.Chats)
+				return ca.NChat.Layout(gtx, th, &ui.ChatList.Selected, &store.Chats)
+			} else if ca.Selected == "_broadcast" {
+				return ca.Broadcast.Layout(gtx, th)
+			}
+			if ca.NChat.LastSelected != ca.Selected {
+				ca.ReplyTo = nil
 			}
 			ca.NChat.LastSelected = ca.Selected
 			if ca.Chat.PeerName != ca.Selected {
 				return D{}
 			}
-			if len(ca.Chat.Messages) == 0 {
-				gx := *(&gtx)
-				gx.Constraints.Max.Y -= 55
-				gx.Constraints.Min.Y = gx.Constraints.Max.Y
-				return layout.Flex{Alignment: layout.Middle, Axis: layout.Vertical}.Layout(gx,
-					layout.Rigid(layout.Spacer{Height: unit.Dp(15)}.Layout),
-					layout.Rigid(material.Body2(th, "There's nothing...").Layout),
-				)
+			ca.handleMsgActions()
+			if viewer.IsOpen() {
+				return layout.UniformInset(unit.Dp(15)).Layout(gtx, func(gtx C) D {
+					return viewer.Layout(gtx, th)
+				})
 			}
-			return layout.UniformInset(unit.Dp(15)).Layout(gtx, func(gtx C) D {
-				gx := *(&gtx)
-				gx.Constraints.Max.Y -= 55
-				return material.List(th, ca.List).Layout(
-					gx,
-					len(ca.Chat.Messages),
-					func(gtx C, ind int) D { return ca.Chat.Messages[ind].Layout(gtx, th, ca.Chat.PeerName) },
-				)
-			},
+			if ca.Contact.EditBtn.Button.Clicked() {
+				ca.Contact.Show(ca.Selected)
+			}
+			if ca.Group.EditBtn.Button.Clicked() && ca.Chat.Group != nil {
+				ca.Group.Show(*ca.Chat.Group)
+			}
+			if ca.Chat.Group != nil && ca.Group.Open && ca.Group.ID == ca.Chat.Group.ID {
+				return ca.Group.Layout(gtx, th, ca.Chat)
+			}
+			if ca.AcceptBtn.Button.Clicked() {
+				ca.Chat.Request = false
+				if err := contacts.Put(Contact{Nick: ca.Selected}); err != nil {
+					errl.Println(err)
+					dialog.Message("Error saving contact").Title("Error!!1").Error()
+				}
+			}
+			if ca.BlockBtn.Button.Clicked() {
+				ca.toggleBlock()
+			}
+			if ca.Contact.Open && ca.Contact.Nick == ca.Selected {
+				return ca.Contact.Layout(gtx, th)
+			}
+			return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+				layout.Rigid(func(gtx C) D {
+					return layout.Inset{Top: unit.Dp(10), Left: unit.Dp(15), Right: unit.Dp(15)}.Layout(gtx, func(gtx C) D {
+						return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+							layout.Rigid(func(gtx C) D {
+								if ca.Chat.Group != nil {
+									return ca.Group.EditBtn.Layout(gtx)
+								}
+								return ca.Contact.EditBtn.Layout(gtx)
+							}),
+							wspacer,
+							layout.Flexed(1, func(gtx C) D {
+								switch {
+								case ca.Chat.Group != nil:
+									return material.Caption(th, strings.Join(ca.Chat.Group.Members, ", ")).Layout(gtx)
+								case isBlocked(ca.Selected):
+									return material.Caption(th, "You blocked "+ca.Selected+", new messages from them are hidden, but kept in history").Layout(gtx)
+								case ca.Chat.Request:
+									return material.Caption(th, ca.Selected+" isn't in your contacts. Accept message request?").Layout(gtx)
+								}
+								c, _ := contacts.Get(ca.Selected)
+								return material.Caption(th, c.Note).Layout(gtx)
+							}),
+							layout.Rigid(func(gtx C) D {
+								if !ca.Chat.Request || isBlocked(ca.Selected) {
+									return D{}
+								}
+								return layout.Inset{Left: stdDP}.Layout(gtx, ca.AcceptBtn.Layout)
+							}),
+							layout.Rigid(func(gtx C) D {
+								if !ca.Chat.Request && !isBlocked(ca.Selected) {
+									return D{}
+								}
+								ca.BlockBtn.Text = "Block"
+								if isBlocked(ca.Selected) {
+									ca.BlockBtn.Text = "Unblock"
+								}
+								return layout.Inset{Left: stdDP}.Layout(gtx, ca.BlockBtn.Layout)
+							}),
+						)
+					})
+				}),
+				layout.Flexed(1, func(gtx C) D {
+					if len(ca.Chat.Messages) == 0 {
+						return layout.Flex{Alignment: layout.Middle, Axis: layout.Vertical}.Layout(gtx,
+							layout.Rigid(layout.Spacer{Height: unit.Dp(15)}.Layout),
+							layout.Rigid(material.Body2(th, "There's nothing...").Layout),
+						)
+					}
+					if ca.Chat.OlderBtn.Clicked() {
+						c := ca.Chat
+						c.LoadOlder(func(n int) {
+							if ca.Chat == c {
+								ca.List.Position.First += n // so view isn't moved
+							}
+						})
+					}
+					older := 0
+					if ca.Chat.HasOlder {
+						older = 1
+					}
+					var read []string
+					defer func() { sendReceipt(ca.Chat, receiptRead, read) }()
+					return layout.UniformInset(unit.Dp(15)).Layout(gtx, func(gtx C) D {
+						return material.List(th, ca.List).Layout(
+							gtx,
+							len(ca.Chat.Messages)+older,
+							func(gtx C, ind int) D {
+								if ind < older {
+									return layout.Center.Layout(gtx, material.Button(th, ca.Chat.OlderBtn, "Load older messages").Layout)
+								}
+								ind -= older
+								// message is laid out only when it's visible, so it's read
+								if g := &ca.Chat.Messages[ind]; g.From != conf.Name && g.Type != ctSystem {
+									g.Seen = true
+									if !g.ReadSent && !g.readSending {
+										read = append(read, g.ID)
+									}
+								}
+								return ca.Chat.Messages[ind].Layout(gtx, th, ca.Chat.PeerName)
+							},
+						)
+					},
+					)
+				}),
 			)
 		}),
 		layout.Rigid(func(gtx C) D {
 			if strings.HasPrefix(ca.Selected, "_") {
 				return D{}
 			}
-			if ca.SendBtn.Button.Clicked() || isSubmit(ca.Input) {
-				txt := strings.TrimSpace(ca.Input.Editor.Text())
-				if len([]rune(txt)) != 0 {
-					err := sendMessage(conf.Token, txt, ca.Chat.PeerName)
+			if ca.CancelReplyBtn.Clicked() {
+				ca.ReplyTo = nil
+			}
+			raw := strings.TrimSpace(ca.Input.Editor.Text())
+			txt := strings.TrimPrefix(raw, cmdPrefix+cmdPrefix) // doubled prefix escapes command
+			if txt != raw {
+				txt = cmdPrefix + txt
+			}
+			if ca.ReplyTo != nil && txt != "" {
+				txt = replyText(*ca.ReplyTo, txt)
+			}
+			txtLen := len([]rune(txt))
+			if ca.AttachBtn.Button.Clicked() && ca.Chat.Group != nil {
+				dialog.Message("Files can't be sent to groups").Title("0_0").Info()
+			} else if ca.AttachBtn.Button.Clicked() {
+				go func(c *Chat) {
+					path, err := dialog.File().Title("Send file").Load()
 					if err != nil {
+						if err != dialog.ErrCancelled {
+							errl.Println(err)
+						}
+						return
+					}
+					if err := transfers.Offer(c, path); err != nil {
 						errl.Println(err)
-						dialog.Message("Error sending your message :(").Title("Error!!1").Error()
-						return D{}
+						dialog.Message("Error sending file: %v", err).Title("Error!!1").Error()
 					}
-					ca.Chat.Messages = append(ca.Chat.Messages, GUIMessage{conf.Name, txt})
+				}(ca.Chat)
+			}
+			maxLen := ca.Chat.MaxTextLen()
+			submit, changed := editorEvents(ca.Input)
+			if _, _, isCmd := parseCommand(raw); changed && txtLen != 0 && !isCmd {
+				ca.Chat.NotifyTyping()
+			}
+			if ca.SendBtn.Button.Clicked() || submit {
+				if runCommand(&cmdContext{ui: ui, ca: ca, chat: ca.Chat}, raw) {
 					ca.Input.Editor.SetText("")
+					txtLen = 0
+					ui.Win.Invalidate()
+				} else if txtLen != 0 && txtLen <= maxLen {
+					replyTo := ""
+					if ca.ReplyTo != nil {
+						replyTo = ca.ReplyTo.ID
+					}
+					ca.Chat.SendText(txt, replyTo, func(err error) {
+						ui.Win.Invalidate()
+						if err != nil {
+							dialog.Message("Error sending your message :(").Title("Error!!1").Error()
+						}
+					})
+					ca.Input.Editor.SetText("")
+					ca.ReplyTo = nil
+					txtLen = 0
 				}
 			}
-			return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
-				layout.Rigid(
-					func(gtx C) D {
-						gx := *(&gtx)
-						gx.Constraints.Max.X -= 90
-						gx.Constraints.Min.X = gx.Constraints.Max.X
-						return widget.Border{
-							Width:        unit.Dp(0.5),
-							Color:        th.Fg,
-							CornerRadius: unit.Dp(3),
-						}.Layout(gx,
-							func(gtx C) D {
-								return layout.UniformInset(unit.Dp(5)).Layout(gtx, ca.Input.Layout)
-							},
+			input := func(gtx C) D {
+				return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+					layout.Rigid(
+						func(gtx C) D {
+							gx := *(&gtx)
+							gx.Constraints.Max.X -= 90
+							gx.Constraints.Min.X = gx.Constraints.Max.X
+							// input grows with text until maxInputHeight, then scrolls
+							if h := gx.Px(maxInputHeight); gx.Constraints.Max.Y > h {
+								gx.Constraints.Max.Y = h
+							}
+							return widget.Border{
+								Width:        unit.Dp(0.5),
+								Color:        th.Fg,
+								CornerRadius: unit.Dp(3),
+							}.Layout(gx,
+								func(gtx C) D {
+									return layout.UniformInset(unit.Dp(5)).Layout(gtx, ca.Input.Layout)
+								},
+							)
+						},
+					),
+					layout.Rigid(layout.Spacer{Width: unit.Dp(15)}.Layout),
+					layout.Rigid(func(gtx C) D {
+						return layout.Flex{Axis: layout.Vertical, Alignment: layout.Middle}.Layout(gtx,
+							layout.Rigid(ca.AttachBtn.Layout),
+							layout.Rigid(layout.Spacer{Height: unit.Dp(5)}.Layout),
+							layout.Rigid(ca.SendBtn.Layout),
+							layout.Rigid(layout.Spacer{Height: unit.Dp(5)}.Layout),
+							layout.Rigid(func(gtx C) D {
+								l := material.Caption(th, fmt.Sprintf("%d/%d", txtLen, maxLen))
+								if txtLen > maxLen {
+									l.Color = color.NRGBA{R: 255, A: 255}
+								}
+								return l.Layout(gtx)
+							}),
 						)
-					},
-				),
-				layout.Rigid(layout.Spacer{Width: unit.Dp(15)}.Layout),
+					}),
+				)
+			}
+			return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
 				layout.Rigid(func(gtx C) D {
-					return ca.SendBtn.Layout(gtx)
+					return ca.layoutCompletion(gtx, th, raw)
+				}),
+				layout.Rigid(func(gtx C) D {
+					if ca.ReplyTo == nil {
+						return D{}
+					}
+					return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+						layout.Rigid(msgIconButton(th, ca.CancelReplyBtn, cancelIcon, "Cancel reply").Layout),
+						wspacer,
+						layout.Flexed(1, func(gtx C) D {
+							l := material.Caption(th, "Reply to "+ca.ReplyTo.From+": "+excerpt(ca.ReplyTo.Text))
+							l.MaxLines = 1
+							return l.Layout(gtx)
+						}),
+					)
 				}),
+				layout.Rigid(input),
 			)
 		}),
 	)
@@ -405,37 +827,112 @@ func th2w(next func(C, T) D, th T) func(C) D {
 	}
 }
 
-func messageGetter(ch chan message, chats *[]*Chat, inv func()) {
-	t := time.NewTicker(2 * time.Second)
-MGFOR:
-	for {
-		<-t.C
-		var (
-			m  message
-			ok bool
-		)
-		select {
-		case m, ok = <-ch:
-		case <-t.C:
-			continue MGFOR
+// messageGetter gets messages from server as soon as they come;
+// chats are changed by handleMessage on UI goroutine
+func messageGetter(ch chan message) {
+	for m := range ch {
+		m := m
+		if isBlocked(m.From) {
+			// it's quarantined: it isn't shown, but it's stored, so user can read it after unblocking
+			if g, env := newGUIMessage(m.From, m.Message); m.Type != "typing" && !isControlType(g.Type) && g.Type != ctGroupNotice {
+				if g.Type == ctSystem {
+					g.Type = ctText
+				}
+				peer := m.From
+				if info := (Group{}); env != nil && env.Attr("group", &info) {
+					// message to group is stored with group, if it's really written by member
+					grp, ok := groups.Get(info.ID)
+					if !ok || !grp.HasMember(m.From) || g.Type == ctFileOffer {
+						continue
+					}
+					peer = grp.Key()
+				}
+				history.Queue(peer, g)
+			}
+			continue
 		}
-		if !ok {
+		presence.Set(m.From, true) // it sends something, so it's online
+		if m.Type == "typing" {    // server's own typing frame
+			store.Do(func() {
+				if c := GetByPN(store.Chats, m.From); c.PeerName != "" {
+					c.SetTyping()
+				}
+			})
 			continue
 		}
-		var c *Chat
-		if c = GetByPN(*chats, m.From); c.PeerName == "" {
-			*chats = append(*chats, &Chat{m.From, []GUIMessage{
-				GUIMessage{
-					From: m.From,
-					Text: m.Message,
-				},
-			}, new(widget.Clickable)})
+		g, env := newGUIMessage(m.From, m.Message)
+		if dedup.Seen(g.ID) {
+			continue
+		}
+		if g.Type == ctSystem { // only local messages may be system ones
+			g.Type = ctText
 		}
-		c.Messages = append(c.Messages, GUIMessage{
-			From: m.From,
-			Text: m.Message,
+		switch g.Type {
+		case ctFileAnswer, ctFileChunk, ctFileResend:
+			// chunks are written here, so UI doesn't wait for disk
+			if info := (Group{}); env == nil || !env.Attr("group", &info) { // files aren't supported in groups
+				transfers.HandleControl(m.From, g.Type, env)
+			}
+			continue
+		}
+		store.Do(func() {
+			handleMessage(m.From, g, env)
+			diag.Record(time.Since(m.got))
 		})
-		inv()
+	}
+}
+
+// handleMessage adds got message to it's chat or handles control message; it's called on UI goroutine
+func handleMessage(from string, g GUIMessage, env *envelope) {
+	if info := (Group{}); env != nil && env.Attr("group", &info) {
+		handleGroupMessage(from, g, env, info)
+		return
+	}
+	if g.Type == ctGroupNotice { // it's useless without group
+		return
+	}
+	var c *Chat
+	if c = GetByPN(store.Chats, from); c.PeerName == "" {
+		if isControlType(g.Type) {
+			return
+		}
+		c = newChat(from)
+		if _, ok := contacts.Get(from); !ok {
+			c.Request = true // first message from stranger
+		}
+		store.Chats = append(store.Chats, c)
+	}
+	if env != nil {
+		c.Envelopes = true
+	}
+	if !isControlType(g.Type) && g.Type != ctFileOffer { // offer mustn't be missed, it waits for answer
+		if ok, markerID, marker := flood.Allow(c.PeerName); !ok {
+			// it isn't shown, but it's stored, so user can show it later
+			history.Queue(c.PeerName, g)
+			c.SetMarker(markerID, marker, g.ID)
+			return
+		}
+	}
+	switch g.Type {
+	case ctTyping:
+		c.SetTyping()
+		return
+	case ctReceipt:
+		applyReceipt(c, env)
+		return
+	case ctFileOffer:
+		transfers.HandleOffer(c, g, env)
+	}
+	c.TypingUntil = time.Time{} // message is typed
+	if c.AddMessage(g) {
+		history.Queue(c.PeerName, g)
+		if !c.Request { // stranger mustn't talk to bots and scripts before user accepts it
+			ipc.Publish(g, c.PeerName)
+			webhooks.Dispatch(g, c.PeerName)
+		}
+		if env != nil {
+			sendReceipt(c, receiptDelivered, []string{g.ID})
+		}
 	}
 }
 
@@ -444,6 +941,221 @@ type Chat struct {
 	PeerName string
 	Messages []GUIMessage
 	Button   *widget.Clickable
+	// Envelopes is true if peer's client sends envelopes (so it understands them)
+	Envelopes      bool
+	TypingUntil    time.Time
+	lastTypingSent time.Time
+	// Request is true if chat was started by stranger and user hasn't accepted it yet
+	Request bool
+	// HasOlder is true if history has messages which aren't in memory
+	HasOlder bool
+	OlderBtn *widget.Clickable
+	// Group is nil if it's chat with one peer
+	Group *Group
+	// suppressed are ids of messages hidden by every flood marker
+	suppressed map[string][]string
+	// loadingOlder is true while older messages are loaded
+	loadingOlder bool
+}
+
+// Title returns name of chat shown to user
+func (c *Chat) Title() string {
+	if c.Group != nil {
+		return c.Group.Name
+	}
+	return contacts.DisplayName(c.PeerName)
+}
+
+// newChat creates chat; it's history is loaded in background
+func newChat(peer string) *Chat {
+	if !strings.HasPrefix(peer, groupPrefix) {
+		presence.Watch(peer)
+	}
+	c := &Chat{
+		PeerName: peer,
+		Button:   new(widget.Clickable),
+		OlderBtn: new(widget.Clickable),
+	}
+	go c.loadHistory()
+	return c
+}
+
+// loadHistory loads last page of history and puts it before messages
+// which were added while it was loaded
+func (c *Chat) loadHistory() {
+	history.Flush() // queued messages must be loaded too
+	msgs, more, err := history.LoadPage(c.PeerName, "", time.Time{}, maxChatMessages)
+	if err != nil {
+		errl.Println(err)
+	}
+	markPending(c.PeerName, msgs)
+	store.Do(func() {
+		have := make(map[string]bool, len(c.Messages))
+		for _, g := range c.Messages {
+			have[g.ID] = true
+		}
+		old := msgs[:0]
+		for _, g := range msgs {
+			if !have[g.ID] {
+				old = append(old, g)
+			}
+		}
+		c.Messages = append(old, c.Messages...)
+		c.HasOlder = c.HasOlder || more
+		c.trim()
+	})
+}
+
+// markPending sets status of messages which are in outbox
+func markPending(peer string, msgs []GUIMessage) {
+	pending := outbox.Pending(peer)
+	for i := range msgs {
+		if pending[msgs[i].ID] {
+			msgs[i].Status = msgWaiting
+		}
+	}
+}
+
+// AddMessage adds message if chat doesn't have message with same id;
+// if it has, status of that message is updated. Returns true if message was added
+func (c *Chat) AddMessage(g GUIMessage) bool {
+	for i := range c.Messages {
+		if c.Messages[i].ID == g.ID {
+			if g.Status.rank() > c.Messages[i].Status.rank() {
+				c.Messages[i].Status = g.Status
+			}
+			return false
+		}
+	}
+	c.Messages = append(c.Messages, g)
+	c.trim()
+	return true
+}
+
+// MaxTextLen returns max length of text in chat; envelope of group messages is longer
+func (c *Chat) MaxTextLen() int {
+	if c.Group == nil {
+		return maxTextLen()
+	}
+	env := newEnvelope(ctText)
+	env.ReplyTo = env.ID
+	if err := env.SetAttr("group", *c.Group); err != nil {
+		errl.Println(err)
+	}
+	return store.MaxMessageLen() - envelopeLen(env)
+}
+
+// SendText sends text message; local echo is shown at once and marked as sent when server answers.
+// If peer is offline, message is queued in outbox. It must be called on UI goroutine; done is called in other one
+func (c *Chat) SendText(txt, replyTo string, done func(error)) {
+	c.sendText(txt, replyTo, func(_ string, err error) { done(err) })
+}
+
+// sendText is SendText, but done also gets id of message
+func (c *Chat) sendText(txt, replyTo string, done func(id string, err error)) {
+	env := newEnvelope(ctText)
+	env.ReplyTo = replyTo
+	g := GUIMessage{
+		ID:      env.ID,
+		From:    conf.Name,
+		Text:    txt,
+		Type:    env.Type,
+		ReplyTo: env.ReplyTo,
+		Time:    time.Now(),
+		Status:  msgSending,
+	}
+	if presence.IsOffline(c.PeerName) {
+		// it will be sent when peer comes online
+		g.Status = msgWaiting
+	}
+	c.AddMessage(g)
+	c.Request = false // user answers, so it's accepted
+	if c.Group != nil {
+		grp := *c.Group
+		go func() {
+			err := errNotMember
+			if grp.HasMember(store.Name()) {
+				err = sendToGroup(grp, txt, g, env, grp.Others())
+			}
+			g.Status = msgSent
+			if err != nil {
+				g.Status = msgFailed
+			}
+			st := g.Status
+			store.Do(func() { c.SetStatus(g.ID, st) })
+			if err := history.Append(c.PeerName, g); err != nil {
+				errl.Println(err)
+			}
+			done(g.ID, err)
+		}()
+		return
+	}
+	go func() {
+		if g.Status != msgWaiting {
+			err := sendEnvelope(store.Token(), g.Text, c.PeerName, env)
+			if err == nil {
+				g.Status = msgSent
+				store.Do(func() { c.SetStatus(g.ID, msgSent) })
+				if err := history.Append(c.PeerName, g); err != nil {
+					errl.Println(err)
+				}
+				done(g.ID, nil)
+				return
+			}
+			errl.Println(err)
+			// peer may have gone offline or server may be unreachable; then message waits in outbox
+			if online, exists, e := isOnline(c.PeerName); e == nil && (online || !exists) {
+				g.Status = msgFailed
+				store.Do(func() { c.SetStatus(g.ID, msgFailed) })
+				if err := history.Append(c.PeerName, g); err != nil {
+					errl.Println(err)
+				}
+				done(g.ID, err)
+				return
+			}
+			presence.Set(c.PeerName, false) // message is sent when peer is seen online again
+			g.Status = msgWaiting
+			store.Do(func() { c.SetStatus(g.ID, msgWaiting) })
+		}
+		err := outbox.Queue(c.PeerName, g, env)
+		if err != nil {
+			errl.Println(err)
+			g.Status = msgFailed
+			store.Do(func() { c.SetStatus(g.ID, msgFailed) })
+		}
+		if err := history.Append(c.PeerName, g); err != nil {
+			errl.Println(err)
+		}
+		if !presence.IsOffline(c.PeerName) { // it came online while message was queued
+			go outbox.Flush(c.PeerName)
+		}
+		done(g.ID, err)
+	}()
+}
+
+// IsKnown returns true if peer is in contacts or user wrote to it, so peer is not a stranger
+func (c *Chat) IsKnown() bool {
+	if _, ok := contacts.Get(c.PeerName); ok {
+		return true
+	}
+	for i := range c.Messages {
+		if c.Messages[i].From == conf.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// SetStatus sets status of message with id, if it isn't lower than current
+func (c *Chat) SetStatus(id string, st msgStatus) {
+	for i := range c.Messages {
+		if c.Messages[i].ID == id {
+			if st.rank() >= c.Messages[i].Status.rank() {
+				c.Messages[i].Status = st
+			}
+			return
+		}
+	}
 }
 
 // GetByPN returns chat by peername
@@ -460,7 +1172,7 @@ func getSmallStr(c *Chat) string {
 	if len(c.Messages) == 0 {
 		return "[no messages]"
 	}
-	t := c.Messages[len(c.Messages)-1].Text
+	t := strings.Join(strings.Fields(c.Messages[len(c.Messages)-1].Text), " ")
 	if len([]rune(t)) > 21 {
 		t = string([]rune(t)[:18]) + "..."
 	}
@@ -496,7 +1208,25 @@ func (c *Chat) LayoutList(gtx C, th T, cl *ChatList) D {
 						Axis:      layout.Vertical,
 						Alignment: layout.Start,
 					}.Layout(gtx,
-						layout.Rigid(material.Body2(th, c.PeerName).Layout),
+						layout.Rigid(func(gtx C) D {
+							return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+								layout.Rigid(func(gtx C) D {
+									return layoutPresenceDot(gtx, c.PeerName)
+								}),
+								layout.Rigid(layout.Spacer{Width: unit.Dp(5)}.Layout),
+								layout.Rigid(func(gtx C) D {
+									name := c.Title()
+									if ct, ok := contacts.Get(c.PeerName); ok && ct.Favourite {
+										name = "★ " + name
+									}
+									l := material.Body2(th, name)
+									if col, ok := contacts.ColorOf(c.PeerName); ok {
+										l.Color = col
+									}
+									return l.Layout(gtx)
+								}),
+							)
+						}),
 						layout.Rigid(layout.Spacer{Height: unit.Dp(7.5)}.Layout),
 						layout.Rigid(material.Label(th, unit.Dp(12.5), getSmallStr(c)).Layout),
 					)
@@ -508,13 +1238,155 @@ func (c *Chat) LayoutList(gtx C, th T, cl *ChatList) D {
 
 // GUIMessage is message
 type GUIMessage struct {
-	From string
-	Text string
+	ID      string
+	From    string
+	Text    string
+	Type    string
+	ReplyTo string
+	Time    time.Time
+	Status  msgStatus
+	// Seen is true if user saw got message; ReadSent is true if read receipt of it was sent
+	Seen     bool
+	ReadSent bool
+	// readSending is true while read receipt is being sent
+	readSending bool
+	W           *MessageWidgets
+}
+
+type msgStatus int
+
+const (
+	msgSent msgStatus = iota // also status of all got messages
+	msgSending
+	msgFailed
+	msgDelivered
+	msgRead
+	msgWaiting // peer is offline, so message is in outbox
+)
+
+// newGUIMessage makes GUIMessage from got text, decoding it's envelope.
+// Messages from old clients get local id and nil envelope
+func newGUIMessage(from, raw string) (GUIMessage, *envelope) {
+	txt, env := proto.Decode(raw)
+	if env == nil {
+		return GUIMessage{ID: proto.NewMessageID(), From: from, Text: txt, Type: ctText, Time: time.Now()}, nil
+	}
+	return GUIMessage{
+		ID:      env.ID,
+		From:    from,
+		Text:    txt,
+		Type:    env.Type,
+		ReplyTo: env.ReplyTo,
+		Time:    time.Now(),
+	}, env
+}
+
+// MessageWidgets is state of message's widgets; it's created on first layout
+type MessageWidgets struct {
+	CopyBtn   *widget.Clickable
+	SelectBtn *widget.Clickable
+	Selecting bool
+	// Selector is editor used to select text; every change of it is reverted
+	Selector *widget.Editor
+	// Action is what user chose in menu; it's handled by ChatActivity
+	Action      msgAction
+	MenuOpen    bool
+	MenuPos     f32.Point
+	ReplyBtn    *widget.Clickable
+	QuoteBtn    *widget.Clickable
+	MenuCopyBtn *widget.Clickable
+	DeleteBtn   *widget.Clickable
+	BlockBtn    *widget.Clickable
+	// ShowBtn is click on system message, e.g. on flood marker
+	ShowBtn   *widget.Clickable
+	pressedAt time.Duration
+	menuKey   int
+	// md is parsed text, it's parsed again only if mdText differs from text
+	md     []mdBlock
+	mdText string
+}
+
+type msgAction int
+
+const (
+	actNone msgAction = iota
+	actReply
+	actQuote
+	actDelete
+	actBlock // blocks or unblocks peer
+	actShow  // shows messages hidden by flood marker
+)
+
+// longPress is how long touch should be to open message menu
+const longPress = 500 * time.Millisecond
+
+var (
+	copyIcon   = getIcon(icons.ContentContentCopy)
+	selectIcon = getIcon(icons.ContentSelectAll)
+	cancelIcon = getIcon(icons.NavigationClose)
+	fileIcon   = getIcon(icons.EditorAttachFile)
+	// ticks near own messages
+	sentIcon      = getIcon(icons.ActionDone)
+	deliveredIcon = getIcon(icons.ActionDoneAll)
+)
+
+// msgIconButton is small button shown near message
+func msgIconButton(th T, btn *widget.Clickable, icon *widget.Icon, desc string) material.IconButtonStyle {
+	b := material.IconButton(th, btn, icon, desc)
+	b.Size = unit.Dp(12)
+	b.Inset = layout.UniformInset(unit.Dp(3))
+	b.Background = color.NRGBA{}
+	b.Color = mutedColor(th.Fg, 0x90)
+	return b
 }
 
 // Layout layouts
-func (g GUIMessage) Layout(gtx C, th T, chname string) D {
-	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+func (g *GUIMessage) Layout(gtx C, th T, chname string) D {
+	if g.W == nil {
+		g.W = &MessageWidgets{
+			CopyBtn:     new(widget.Clickable),
+			SelectBtn:   new(widget.Clickable),
+			Selector:    new(widget.Editor),
+			ReplyBtn:    new(widget.Clickable),
+			QuoteBtn:    new(widget.Clickable),
+			MenuCopyBtn: new(widget.Clickable),
+			DeleteBtn:   new(widget.Clickable),
+			BlockBtn:    new(widget.Clickable),
+			ShowBtn:     new(widget.Clickable),
+		}
+	}
+	if g.Type == ctSystem || g.Type == ctGroupNotice {
+		if g.W.ShowBtn.Clicked() {
+			g.W.Action = actShow
+		}
+		return layout.Inset{Bottom: unit.Dp(10)}.Layout(gtx, func(gtx C) D {
+			gtx.Constraints.Min.X = gtx.Constraints.Max.X
+			return layout.Center.Layout(gtx, func(gtx C) D {
+				return g.W.ShowBtn.Layout(gtx, material.Caption(th, g.Text).Layout)
+			})
+		})
+	}
+	w := g.W
+	if w.CopyBtn.Clicked() {
+		clipboard.WriteOp{Text: g.Text}.Add(gtx.Ops)
+	}
+	g.processMenu(gtx)
+	if w.SelectBtn.Clicked() {
+		w.Selecting = !w.Selecting
+		if w.Selecting {
+			w.Selector.SetText(g.Text)
+			w.Selector.Focus()
+		}
+	}
+	if w.Selecting {
+		for _, e := range w.Selector.Events() {
+			if _, ok := e.(widget.ChangeEvent); ok && w.Selector.Text() != g.Text {
+				w.Selector.SetText(g.Text)
+			}
+		}
+	}
+	macro := op.Record(gtx.Ops)
+	dims := layout.Flex{Axis: layout.Vertical}.Layout(gtx,
 		layout.Rigid(func(gtx C) D {
 			gx := *(&gtx)
 			gx.Constraints.Min.X = gx.Constraints.Max.X
@@ -524,28 +1396,258 @@ func (g GUIMessage) Layout(gtx C, th T, chname string) D {
 					t.Fg = color.NRGBA{R: 255, G: 127, A: 255}
 					if g.From == conf.Name {
 						t.Fg = color.NRGBA{G: 127, B: 127, A: 255}
+					} else if col, ok := contacts.ColorOf(g.From); ok {
+						t.Fg = col
 					}
 					return &t
-				}(), "<"+g.From+">\t").Layout),
-				layout.Rigid(material.Label(th, unit.Dp(15), g.Text).Layout),
+				}(), "<"+contacts.DisplayName(g.From)+">\t").Layout),
+				layout.Flexed(1, func(gtx C) D {
+					if w.Selecting {
+						// Ctrl+C in editor copies selection
+						e := material.Editor(th, w.Selector, "")
+						e.TextSize = unit.Dp(15)
+						return e.Layout(gtx)
+					}
+					if t, ok := transfers.Snapshot(g.ID); ok {
+						return layoutTransfer(gtx, th, t)
+					}
+					if conf.RawText {
+						return material.Label(th, unit.Dp(15), g.Text).Layout(gtx)
+					}
+					if w.md == nil || w.mdText != g.Text { // e.g. text of flood marker changes
+						w.md, w.mdText = parseMarkdown(g.Text), g.Text
+					}
+					return layoutMarkdown(gtx, th, unit.Dp(15), w.md)
+				}),
+				layout.Rigid(func(gtx C) D {
+					if g.From != conf.Name {
+						return D{}
+					}
+					var (
+						icon *widget.Icon
+						col  = mutedColor(th.Fg, 0x90)
+					)
+					switch g.Status {
+					case msgSending:
+						return layout.Inset{Left: unit.Dp(5)}.Layout(gtx, material.Caption(th, "sending...").Layout)
+					case msgFailed:
+						return layout.Inset{Left: unit.Dp(5)}.Layout(gtx, material.Caption(th, "not sent").Layout)
+					case msgWaiting:
+						return layout.Inset{Left: unit.Dp(5)}.Layout(gtx, material.Caption(th, "waiting for peer").Layout)
+					case msgSent:
+						icon = sentIcon
+					case msgDelivered:
+						icon = deliveredIcon
+					case msgRead:
+						icon, col = deliveredIcon, th.ContrastBg
+					}
+					return layout.Inset{Left: unit.Dp(5), Top: unit.Dp(3)}.Layout(gtx, func(gtx C) D {
+						gtx.Constraints.Min.X = gtx.Px(unit.Dp(14))
+						return icon.Layout(gtx, col)
+					})
+				}),
+				layout.Rigid(msgIconButton(th, w.SelectBtn, selectIcon, "Select text").Layout),
+				layout.Rigid(msgIconButton(th, w.CopyBtn, copyIcon, "Copy message").Layout),
 			)
 		}),
 		layout.Rigid(layout.Spacer{Height: unit.Dp(10)}.Layout),
 	)
+	call := macro.Stop()
+	// message's widgets are inside of it's area, so they get events too
+	area := clip.Rect{Max: dims.Size}.Push(gtx.Ops)
+	pointer.InputOp{Tag: w, Types: pointer.Press | pointer.Release}.Add(gtx.Ops)
+	call.Add(gtx.Ops)
+	area.Pop()
+	if w.MenuOpen {
+		w.layoutMenu(gtx, th, chname)
+	}
+	return dims
+}
+
+// layoutTransfer layouts file instead of message text
+func layoutTransfer(gtx C, th T, t Transfer) D {
+	if t.AcceptBtn != nil && t.AcceptBtn.Clicked() {
+		transfers.Accept(t.ID)
+	}
+	if t.DeclineBtn != nil && t.DeclineBtn.Clicked() {
+		transfers.Decline(t.ID)
+	}
+	var status string
+	switch t.State {
+	case tsOffered:
+		status = "waiting for answer"
+		if !t.Outgoing {
+			status = "do you want to get this file?"
+		}
+	case tsActive:
+		status = fmt.Sprintf("%d/%d chunks", t.Done, t.Manifest.Chunks)
+	case tsDone:
+		status = "done"
+		if !t.Outgoing {
+			status = "saved to " + t.Path
+		}
+	case tsDeclined:
+		status = "declined"
+	case tsFailed:
+		status = "failed"
+	}
+	if t.Err != "" {
+		status += ": " + t.Err
+	}
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+		layout.Rigid(func(gtx C) D {
+			return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+				layout.Rigid(func(gtx C) D {
+					gtx.Constraints.Min.X = gtx.Px(unit.Dp(18))
+					return fileIcon.Layout(gtx, th.Fg)
+				}),
+				layout.Rigid(layout.Spacer{Width: unit.Dp(5)}.Layout),
+				layout.Flexed(1, material.Body1(th, t.Manifest.Name+" ("+humanSize(t.Manifest.Size)+")").Layout),
+			)
+		}),
+		layout.Rigid(layout.Spacer{Height: unit.Dp(5)}.Layout),
+		layout.Rigid(func(gtx C) D {
+			if t.State != tsActive && t.State != tsDone {
+				return D{}
+			}
+			var progress float32 = 1
+			if t.Manifest.Chunks != 0 {
+				progress = float32(t.Done) / float32(t.Manifest.Chunks)
+			}
+			return material.ProgressBar(th, progress).Layout(gtx)
+		}),
+		layout.Rigid(material.Caption(th, status).Layout),
+		layout.Rigid(func(gtx C) D {
+			if t.State != tsDone || !isImageFile(t.Path) {
+				return D{}
+			}
+			return layoutThumb(gtx, th, t)
+		}),
+		layout.Rigid(func(gtx C) D {
+			if t.Outgoing || t.State != tsOffered {
+				return D{}
+			}
+			return layout.Inset{Top: unit.Dp(5)}.Layout(gtx, func(gtx C) D {
+				return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+					layout.Rigid(material.Button(th, t.AcceptBtn, "Accept").Layout),
+					wspacer,
+					layout.Rigid(material.Button(th, t.DeclineBtn, "Decline").Layout),
+				)
+			})
+		}),
+	)
+}
+
+// processMenu opens menu on right click or long press and handles it's buttons
+func (g *GUIMessage) processMenu(gtx C) {
+	w := g.W
+	for _, e := range gtx.Events(w) {
+		e, ok := e.(pointer.Event)
+		if !ok {
+			continue
+		}
+		switch e.Type {
+		case pointer.Press:
+			w.pressedAt = e.Time
+			if e.Buttons.Contain(pointer.ButtonSecondary) {
+				w.MenuOpen, w.MenuPos = true, e.Position
+			}
+		case pointer.Release:
+			if e.Source == pointer.Touch && e.Time-w.pressedAt >= longPress {
+				w.MenuOpen, w.MenuPos = true, e.Position
+			}
+		}
+	}
+	for _, e := range gtx.Events(&w.menuKey) {
+		if e, ok := e.(pointer.Event); ok && e.Type == pointer.Press {
+			w.MenuOpen = false
+		}
+	}
+	for btn, act := range map[*widget.Clickable]msgAction{
+		w.ReplyBtn:  actReply,
+		w.QuoteBtn:  actQuote,
+		w.DeleteBtn: actDelete,
+		w.BlockBtn:  actBlock,
+	} {
+		if btn.Clicked() {
+			w.Action, w.MenuOpen = act, false
+			op.InvalidateOp{}.Add(gtx.Ops) // so ChatActivity handles it now
+		}
+	}
+	if w.MenuCopyBtn.Clicked() {
+		clipboard.WriteOp{Text: g.Text}.Add(gtx.Ops)
+		w.MenuOpen = false
+	}
+}
+
+// layoutMenu layouts message menu over everything
+func (w *MessageWidgets) layoutMenu(gtx C, th T, chname string) {
+	macro := op.Record(gtx.Ops)
+	// click anywhere else closes menu
+	catcher := clip.Rect(image.Rect(-1e6, -1e6, 1e6, 1e6)).Push(gtx.Ops)
+	pointer.InputOp{Tag: &w.menuKey, Types: pointer.Press}.Add(gtx.Ops)
+	catcher.Pop()
+	stack := op.Offset(w.MenuPos).Push(gtx.Ops)
+	gx := gtx
+	gx.Constraints.Min.X = gtx.Px(unit.Dp(150))
+	gx.Constraints.Max.X = gx.Constraints.Min.X
+	itemWidget := func(gtx C, btn *widget.Clickable, txt string) D {
+		return btn.Layout(gtx, func(gtx C) D {
+			return layout.UniformInset(unit.Dp(7)).Layout(gtx, material.Body2(th, txt).Layout)
+		})
+	}
+	item := func(btn *widget.Clickable, txt string) layout.FlexChild {
+		return layout.Rigid(func(gtx C) D {
+			return itemWidget(gtx, btn, txt)
+		})
+	}
+	itemsMacro := op.Record(gx.Ops)
+	dims := widget.Border{Color: th.Fg, Width: unit.Dp(0.5), CornerRadius: unit.Dp(3)}.Layout(gx,
+		func(gtx C) D {
+			return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+				item(w.ReplyBtn, "Reply"),
+				item(w.QuoteBtn, "Quote"),
+				item(w.MenuCopyBtn, "Copy"),
+				item(w.DeleteBtn, "Delete locally"),
+				layout.Rigid(func(gtx C) D {
+					if strings.HasPrefix(chname, groupPrefix) {
+						return D{}
+					}
+					txt := "Block " + chname
+					if isBlocked(chname) {
+						txt = "Unblock " + chname
+					}
+					return itemWidget(gtx, w.BlockBtn, txt)
+				}),
+			)
+		},
+	)
+	items := itemsMacro.Stop()
+	paint.FillShape(gtx.Ops, th.Bg, clip.Rect{Max: dims.Size}.Op())
+	items.Add(gtx.Ops)
+	stack.Pop()
+	op.Defer(gtx.Ops, macro.Stop())
 }
 
 // HomeTab is tab which shows on start
 type HomeTab struct {
-	ListButton  material.ButtonStyle
-	Settings    widget.Bool
-	ThemeSwitch material.SwitchStyle
-	NameInput   material.EditorStyle
-	PassInput   material.EditorStyle
-	ShowPass    material.SwitchStyle
-	RegBtn      material.ButtonStyle
-	AuthBtn     material.ButtonStyle
-	LogoutBtn   material.ButtonStyle
-	PingBtn     material.ButtonStyle
+	ListButton     material.ButtonStyle
+	Settings       widget.Bool
+	Diag           widget.Bool
+	ThemeSwitch    material.SwitchStyle
+	RawSwitch      material.SwitchStyle
+	TypingSwitch   material.SwitchStyle
+	ReceiptsSwitch material.SwitchStyle
+	ImagesSwitch   material.SwitchStyle
+	NameInput      material.EditorStyle
+	PassInput      material.EditorStyle
+	ShowPass       material.SwitchStyle
+	RegBtn         material.ButtonStyle
+	AuthBtn        material.ButtonStyle
+	LogoutBtn      material.ButtonStyle
+	ImportBtn      material.ButtonStyle
+	ExportBtn      material.ButtonStyle
+	PingBtn        material.ButtonStyle
 }
 
 // LayoutList layouts HomeTab's view in list
@@ -573,7 +1675,7 @@ const allowedSymbols = "QWERTYUIOPASDFGHJKLZXCVBNM" +
 // Layout layouts HomeTab's view instead of chat
 func (ht *HomeTab) Layout(gtx C, th T, ui *UI) D {
 	if ht.ThemeSwitch.Switch.Changed() {
-		conf.IsDark = ht.ThemeSwitch.Switch.Value
+		store.Conf(func() { conf.IsDark = ht.ThemeSwitch.Switch.Value })
 		err := saveConf()
 		if err != nil {
 			errl.Println(err)
@@ -582,16 +1684,45 @@ func (ht *HomeTab) Layout(gtx C, th T, ui *UI) D {
 			dialog.Message("Reload application to see changes").Title("Info").Info() // crutch to fix nullpointer bug
 		}
 	}
+	if ht.RawSwitch.Switch.Changed() {
+		store.Conf(func() { conf.RawText = ht.RawSwitch.Switch.Value })
+		if err := saveConf(); err != nil {
+			errl.Println(err)
+			dialog.Message("Error saving configuration").Title("Error!!1").Error()
+		}
+	}
+	if ht.TypingSwitch.Switch.Changed() {
+		store.Conf(func() { conf.NoTypingNotifs = !ht.TypingSwitch.Switch.Value })
+		if err := saveConf(); err != nil {
+			errl.Println(err)
+			dialog.Message("Error saving configuration").Title("Error!!1").Error()
+		}
+	}
+	if ht.ReceiptsSwitch.Switch.Changed() {
+		store.Conf(func() { conf.NoReadReceipts = !ht.ReceiptsSwitch.Switch.Value })
+		if err := saveConf(); err != nil {
+			errl.Println(err)
+			dialog.Message("Error saving configuration").Title("Error!!1").Error()
+		}
+	}
+	if ht.ImagesSwitch.Switch.Changed() {
+		store.Conf(func() { conf.ImagesOnlyFromKnown = ht.ImagesSwitch.Switch.Value })
+		if err := saveConf(); err != nil {
+			errl.Println(err)
+			dialog.Message("Error saving configuration").Title("Error!!1").Error()
+		}
+	}
 	if ht.PingBtn.Button.Clicked() {
 		go func() {
 			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 			defer cancel()
-			dur, err := ping(ctx, HTTPServerURL)
+			url := store.HTTPURL()
+			dur, err := ping(ctx, url)
 			if err != nil {
 				errl.Println(err)
 				dialog.Message("Error during ping").Title("Ping result").Error()
 			} else {
-				dialog.Message("Server: %s, time: %s", HTTPServerURL, dur).Title("Ping result").Info()
+				dialog.Message("Server: %s, time: %s", url, dur).Title("Ping result").Info()
 			}
 		}()
 	}
@@ -630,6 +1761,38 @@ func (ht *HomeTab) Layout(gtx C, th T, ui *UI) D {
 					)
 				}),
 				hspacer,
+				layout.Rigid(func(gtx C) D {
+					return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+						layout.Rigid(material.Label(th, unit.Dp(15), "Show raw message text:\t").Layout),
+						layout.Rigid(layout.Spacer{Width: unit.Dp(5)}.Layout),
+						layout.Rigid(ht.RawSwitch.Layout),
+					)
+				}),
+				hspacer,
+				layout.Rigid(func(gtx C) D {
+					return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+						layout.Rigid(material.Label(th, unit.Dp(15), "Send typing notifications:\t").Layout),
+						layout.Rigid(layout.Spacer{Width: unit.Dp(5)}.Layout),
+						layout.Rigid(ht.TypingSwitch.Layout),
+					)
+				}),
+				hspacer,
+				layout.Rigid(func(gtx C) D {
+					return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+						layout.Rigid(material.Label(th, unit.Dp(15), "Send read receipts:\t").Layout),
+						layout.Rigid(layout.Spacer{Width: unit.Dp(5)}.Layout),
+						layout.Rigid(ht.ReceiptsSwitch.Layout),
+					)
+				}),
+				hspacer,
+				layout.Rigid(func(gtx C) D {
+					return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+						layout.Rigid(material.Label(th, unit.Dp(15), "Don't load images from strangers:\t").Layout),
+						layout.Rigid(layout.Spacer{Width: unit.Dp(5)}.Layout),
+						layout.Rigid(ht.ImagesSwitch.Layout),
+					)
+				}),
+				hspacer,
 				layout.Rigid(material.H5(th, "Account:\t").Layout),
 				hspacer,
 				layout.Rigid(func(gtx C) D {
@@ -699,9 +1862,9 @@ func (ht *HomeTab) Layout(gtx C, th T, ui *UI) D {
 								dialog.Message(wr).Title("Error!!1").Error()
 								return D{}
 							}
-							conf.Name = ntxt
-							conf.Token = token
+							store.SetAccount(ntxt, token)
 							initAPI()
+							store.Chats = savedChats()
 							err = saveConf()
 							if err != nil {
 								errl.Println(err)
@@ -775,13 +1938,52 @@ func (ht *HomeTab) Layout(gtx C, th T, ui *UI) D {
 							}),
 						)
 					}
+					if ht.ExportBtn.Button.Clicked() {
+						go func() {
+							path, err := dialog.File().Filter("TOML file", "toml").Title("Export contacts").Save()
+							if err != nil {
+								if err != dialog.ErrCancelled {
+									errl.Println(err)
+								}
+								return
+							}
+							if err := contacts.Export(path); err != nil {
+								errl.Println(err)
+								dialog.Message("Error exporting contacts").Title("Error!!1").Error()
+							}
+						}()
+					}
+					if ht.ImportBtn.Button.Clicked() {
+						go func() {
+							path, err := dialog.File().Filter("TOML file", "toml").Title("Import contacts").Load()
+							if err != nil {
+								if err != dialog.ErrCancelled {
+									errl.Println(err)
+								}
+								return
+							}
+							nicks, err := contacts.Import(path)
+							if err != nil {
+								errl.Println(err)
+								dialog.Message("Error importing contacts").Title("Error!!1").Error()
+							}
+							store.Do(func() {
+								for _, nick := range nicks {
+									if GetByPN(store.Chats, nick).PeerName == "" {
+										store.Chats = append(store.Chats, newChat(nick))
+									}
+								}
+							})
+						}()
+					}
 					if ht.LogoutBtn.Button.Clicked() {
 						ok := dialog.Message("Do you realy want to logout?").YesNo()
 						if ok {
 							ok = dialog.Message("Really?").YesNo()
 							if ok {
-								conf.Name, conf.Token = "", ""
-								_ = goOffline(conf.Token) // it will stop heartbeat and close connection
+								presence.Save()
+								store.SetAccount("", "")
+								_ = goOffline(store.Token()) // it will stop heartbeat and close connection
 								err := saveConf()
 								if err != nil {
 									errl.Println(err)
@@ -789,8 +1991,11 @@ func (ht *HomeTab) Layout(gtx C, th T, ui *UI) D {
 									os.Exit(1)
 
 								}
-								ui.ChatList.Chats = []*Chat{}
-								TCPConn.Close()
+								store.Chats = []*Chat{}
+								if conn := store.Conn(); conn != nil {
+									conn.Close()
+								}
+								store.SetConn(nil)
 								ui.Win.Invalidate()
 								return D{}
 							}
@@ -799,7 +2004,22 @@ func (ht *HomeTab) Layout(gtx C, th T, ui *UI) D {
 					return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
 						layout.Rigid(material.Body2(th, "Nick:\t"+conf.Name).Layout),
 						hspacer,
+						layout.Rigid(func(gtx C) D {
+							if store.Connected() {
+								return material.Body2(th, "Connection:\tonline").Layout(gtx)
+							}
+							return material.Body2(th, "Connection:\treconnecting...").Layout(gtx)
+						}),
+						hspacer,
 						layout.Rigid(ht.LogoutBtn.Layout),
+						hspacer,
+						layout.Rigid(func(gtx C) D {
+							return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+								layout.Rigid(ht.ImportBtn.Layout),
+								wspacer,
+								layout.Rigid(ht.ExportBtn.Layout),
+							)
+						}),
 					)
 				}),
 			)
@@ -807,6 +2027,29 @@ func (ht *HomeTab) Layout(gtx C, th T, ui *UI) D {
 		hspacer,
 		layout.Rigid(ht.PingBtn.Layout),
 		hspacer,
+		layout.Rigid(func(gtx C) D {
+			return ht.Diag.Layout(gtx, func(gtx C) D {
+				return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+					layout.Rigid(func(gtx C) D {
+						var icon *widget.Icon = getIcon(icons.NavigationUnfoldMore)
+						if ht.Diag.Value {
+							icon = getIcon(icons.NavigationUnfoldLess)
+						}
+						return icon.Layout(gtx, th.Fg)
+					}),
+					wspacer,
+					layout.Rigid(material.H6(th, "Diagnostics").Layout),
+				)
+			})
+		}),
+		layout.Rigid(func(gtx C) D {
+			if !ht.Diag.Value {
+				return D{}
+			}
+			op.InvalidateOp{At: gtx.Now.Add(time.Second)}.Add(gtx.Ops) // stats change without redraws
+			return diag.Layout(gtx, th)
+		}),
+		hspacer,
 		layout.Rigid(material.Body1(th, "Support: overmsg@dikey0ficial.rf.gd").Layout),
 	)
 }
@@ -818,6 +2061,43 @@ type NewChatAct struct {
 	NickInput    material.EditorStyle
 	AcceptBtn    material.ButtonStyle
 	CancelBtn    material.ButtonStyle
+	// group is created from name and list of members
+	GroupName      material.EditorStyle
+	GroupMembers   material.EditorStyle
+	CreateGroupBtn material.ButtonStyle
+	// BroadcastBtn opens composer of message to several contacts
+	BroadcastBtn material.ButtonStyle
+}
+
+// createGroup creates group from form and tells members about it
+func (nca *NewChatAct) createGroup(sel *string, chs *[]*Chat) {
+	name := strings.TrimSpace(nca.GroupName.Editor.Text())
+	members := strings.Fields(strings.ReplaceAll(nca.GroupMembers.Editor.Text(), ",", " "))
+	if name == "" || len(members) == 0 {
+		dialog.Message("Group must have name and members").Title("0_0").Info()
+		return
+	}
+	g := Group{ID: proto.NewMessageID(), Name: name, Members: []string{conf.Name}}
+	for _, m := range members {
+		if !isSafeName(m) {
+			dialog.Message("Bad nick: %s", m).Title("0_0").Info()
+			return
+		}
+		if !g.HasMember(m) {
+			g.Members = append(g.Members, m)
+		}
+	}
+	c := newChat(g.Key())
+	if err := changeGroup(c, g, groupNotice{Action: groupCreate, Who: g.Others()}); err != nil {
+		errl.Println(err)
+		dialog.Message("Error creating group").Title("Error!!1").Error()
+		return
+	}
+	*chs = append(*chs, c)
+	*sel = g.Key()
+	nca.GroupName.Editor.SetText("")
+	nca.GroupMembers.Editor.SetText("")
+	nca.Invalidate()
 }
 
 // Layout , вы не поверите, layouts
@@ -859,6 +2139,14 @@ BIG:
 			-(len([]rune(nca.NickInput.Editor.Text())) - 32),
 		)
 	}
+	if nca.CreateGroupBtn.Button.Clicked() || isSubmit(nca.GroupMembers) {
+		nca.createGroup(sel, chs)
+	}
+	if nca.BroadcastBtn.Button.Clicked() {
+		*sel = "_broadcast"
+		nca.Invalidate()
+		return D{}
+	}
 	if (nca.AcceptBtn.Button.Clicked() || isSubmit(nca.NickInput)) && nwarn == "" {
 		is, exs, err := isOnline(txt)
 		if err != nil {
@@ -866,14 +2154,13 @@ BIG:
 			dialog.Message("Error asking server").Title("Error!!1").Error()
 			return D{}
 		}
-		if !is {
-			if exs {
-				dialog.Message("This user is offline").Title("0_0").Info()
-			} else {
-				dialog.Message("This user doesn't exist").Title("0_0").Info()
-			}
+		if exs {
+			presence.Set(txt, is)
+		}
+		if !exs {
+			dialog.Message("This user doesn't exist").Title("0_0").Info()
 		} else {
-			*chs = append(*chs, &Chat{txt, []GUIMessage{}, new(widget.Clickable)})
+			*chs = append(*chs, newChat(txt))
 			*sel = txt
 			nca.Invalidate()
 			nca.NickInput.Editor.SetText("")
@@ -908,6 +2195,38 @@ BIG:
 		}),
 		hspacer,
 		layout.Rigid(nca.CancelBtn.Layout),
+		layout.Rigid(layout.Spacer{Height: unit.Dp(25)}.Layout),
+		layout.Rigid(material.Body1(th, "Or create group:").Layout),
+		hspacer,
+		layout.Rigid(func(gtx C) D {
+			return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+				layout.Rigid(func(gtx C) D {
+					return widget.Border{
+						Color:        th.Fg,
+						Width:        unit.Dp(0.5),
+						CornerRadius: unit.Dp(4),
+					}.Layout(gtx, func(gtx C) D {
+						return layout.UniformInset(unit.Dp(5)).Layout(gtx, nca.GroupName.Layout)
+					})
+				}),
+				wspacer,
+				layout.Rigid(func(gtx C) D {
+					return widget.Border{
+						Color:        th.Fg,
+						Width:        unit.Dp(0.5),
+						CornerRadius: unit.Dp(4),
+					}.Layout(gtx, func(gtx C) D {
+						return layout.UniformInset(unit.Dp(5)).Layout(gtx, nca.GroupMembers.Layout)
+					})
+				}),
+				wspacer,
+				layout.Rigid(nca.CreateGroupBtn.Layout),
+			)
+		}),
+		layout.Rigid(layout.Spacer{Height: unit.Dp(25)}.Layout),
+		layout.Rigid(material.Body1(th, "Or send one message to several contacts:").Layout),
+		hspacer,
+		layout.Rigid(nca.BroadcastBtn.Layout),
 	)
 }
 
@@ -916,6 +2235,19 @@ func getIcon(dat []byte) *widget.Icon {
 	return ic
 }
 
+// editorEvents is like isSubmit, but also tells was text changed
+func editorEvents(w material.EditorStyle) (submit, changed bool) {
+	for _, e := range w.Editor.Events() {
+		switch e.(type) {
+		case widget.SubmitEvent:
+			submit = true
+		case widget.ChangeEvent:
+			changed = true
+		}
+	}
+	return
+}
+
 func isSubmit(w material.EditorStyle) bool {
 	evs := w.Editor.Events()
 	if len(evs) == 0 {
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Webhook is URL where got messages are posted. URL may be remote one,
+// so it should be https and have secret, because messages are sent as they are
+type Webhook struct {
+	URL string `toml:"url"`
+	// Secret is key of HMAC-SHA256 of body, sent in X-Overmsg-Signature header
+	Secret string `toml:"secret"`
+	// From are senders whose messages are posted; if empty, all are
+	From []string `toml:"from"`
+	// Keywords are words one of which message must contain; if empty, all messages are posted
+	Keywords []string `toml:"keywords"`
+	// Retries is how many times failed request is repeated
+	Retries int `toml:"retries"`
+	// Replies enables sending of text from response back to chat
+	Replies bool `toml:"replies"`
+}
+
+// webhookPayload is body of webhook's request
+type webhookPayload struct {
+	ID   string    `json:"id"`
+	From string    `json:"from"`
+	To   string    `json:"to"`
+	Chat string    `json:"chat"` // peer or group, where reply is sent
+	Type string    `json:"type"`
+	Text string    `json:"text"`
+	Time time.Time `json:"time"`
+}
+
+// webhookReply is optional body of webhook's response
+type webhookReply struct {
+	Reply string `json:"reply"`
+}
+
+const (
+	webhookTimeout = 10 * time.Second
+	signatureHdr   = "X-Overmsg-Signature"
+	// maxWebhookReplies is how many replies may be sent to one chat in webhookReplyPeriod;
+	// it stops loops of replies between two bots
+	maxWebhookReplies  = 5
+	webhookReplyPeriod = time.Minute
+)
+
+// Webhooks posts got messages to configured URLs; messages for every URL are
+// posted in order they came
+type Webhooks struct {
+	mu     sync.Mutex
+	queues map[string]chan webhookPayload
+	client *http.Client
+	// replies are times of last replies to every chat
+	replies map[string][]time.Time
+	// retryDelay is delay before first repeat of failed request
+	retryDelay time.Duration
+	// Reply sends reply of webhook to chat
+	Reply func(chat, txt string)
+}
+
+var webhooks = &Webhooks{
+	queues:     make(map[string]chan webhookPayload),
+	client:     &http.Client{Timeout: webhookTimeout},
+	replies:    make(map[string][]time.Time),
+	retryDelay: time.Second,
+	Reply:      sendReply,
+}
+
+// Match tells if message should be posted to hook
+func (h Webhook) Match(from, txt string) bool {
+	if len(h.From) != 0 {
+		var ok bool
+		for _, f := range h.From {
+			if f == from {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if len(h.Keywords) == 0 {
+		return true
+	}
+	txt = strings.ToLower(txt)
+	for _, k := range h.Keywords {
+		if strings.Contains(txt, strings.ToLower(k)) {
+			return true
+		}
+	}
+	return false
+}
+
+// sign returns signature of body
+func (h Webhook) sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(h.Secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatch queues got message for every matching hook; messages of message requests
+// and ones suppressed by flood limiter must not be dispatched
+func (ws *Webhooks) Dispatch(g GUIMessage, chat string) {
+	p := webhookPayload{
+		ID:   g.ID,
+		From: g.From,
+		To:   store.Name(),
+		Chat: chat,
+		Type: g.Type,
+		Text: g.Text,
+		Time: g.Time,
+	}
+	for _, h := range conf.Webhooks {
+		if h.URL == "" || !h.Match(g.From, g.Text) {
+			continue
+		}
+		select {
+		case ws.queue(h) <- p:
+		default:
+			errl.Printf("webhook %s is too slow, message %s is dropped", h.URL, g.ID)
+		}
+	}
+}
+
+// queue returns queue of hook, starting it's worker if needed
+func (ws *Webhooks) queue(h Webhook) chan webhookPayload {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	q, ok := ws.queues[h.URL]
+	if !ok {
+		q = make(chan webhookPayload, recvQueueSize)
+		ws.queues[h.URL] = q
+		go func() {
+			for p := range q {
+				ws.deliver(h, p)
+			}
+		}()
+	}
+	return q
+}
+
+// deliver posts payload, repeating it with growing delay while it fails
+func (ws *Webhooks) deliver(h Webhook, p webhookPayload) {
+	body, err := json.Marshal(p)
+	if err != nil {
+		errl.Println(err)
+		return
+	}
+	delay := ws.retryDelay
+	for try := 0; ; try++ {
+		reply, retry, err := ws.post(h, body)
+		if err == nil {
+			if h.Replies && reply != "" {
+				if !ws.allowReply(p.Chat) {
+					errl.Printf("webhook %s replies to %s too often, reply is dropped", h.URL, p.Chat)
+					return
+				}
+				ws.Reply(p.Chat, reply)
+			}
+			return
+		}
+		errl.Printf("webhook %s: %v", h.URL, err)
+		if !retry || try >= h.Retries {
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// allowReply returns true if reply may be sent to chat now
+func (ws *Webhooks) allowReply(chat string) bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	now := time.Now()
+	recent := ws.replies[chat][:0]
+	for _, t := range ws.replies[chat] {
+		if now.Sub(t) < webhookReplyPeriod {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= maxWebhookReplies {
+		ws.replies[chat] = recent
+		return false
+	}
+	ws.replies[chat] = append(recent, now)
+	return true
+}
+
+// post sends one request; retry tells if error may go away
+func (ws *Webhooks) post(h Webhook, body []byte) (reply string, retry bool, err error) {
+	req, err := http.NewRequest("POST", h.URL, bytes.NewReader(body))
+	if err != nil {
+		return "", false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.Secret != "" {
+		req.Header.Set(signatureHdr, h.sign(body))
+	}
+	resp, err := ws.client.Do(req)
+	if err != nil {
+		return "", true, err
+	}
+	defer resp.Body.Close()
+	dat, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(store.MaxMessageLen())*4+1024))
+	if err != nil {
+		return "", true, err
+	}
+	switch {
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return "", true, fmt.Errorf("status %s", resp.Status)
+	case resp.StatusCode >= 300:
+		return "", false, fmt.Errorf("status %s", resp.Status)
+	}
+	if len(bytes.TrimSpace(dat)) == 0 || !h.Replies {
+		return "", false, nil
+	}
+	var r webhookReply
+	if err := json.Unmarshal(dat, &r); err != nil {
+		return "", false, fmt.Errorf("bad reply: %v", err)
+	}
+	return strings.TrimSpace(r.Reply), false, nil
+}
+
+// sendReply sends webhook's reply to chat, as if user wrote it
+func sendReply(chat, txt string) {
+	if len([]rune(txt)) > maxTextLen() {
+		errl.Printf("reply to %s is too long", chat)
+		return
+	}
+	store.Do(func() {
+		c := GetByPN(store.Chats, chat)
+		if c.PeerName == "" {
+			if strings.HasPrefix(chat, groupPrefix) {
+				return // group was left
+			}
+			c = newChat(chat)
+			store.Chats = append(store.Chats, c)
+		}
+		c.SendText(txt, "", func(err error) {
+			if err != nil {
+				errl.Printf("reply to %s: %v", chat, err)
+			}
+		})
+	})
+}
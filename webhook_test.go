@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhookMatch(t *testing.T) {
+	tests := []struct {
+		hook      Webhook
+		from, txt string
+		want      bool
+	}{
+		{Webhook{}, "alice", "hi", true},
+		{Webhook{From: []string{"alice"}}, "alice", "hi", true},
+		{Webhook{From: []string{"alice"}}, "bob", "hi", false},
+		{Webhook{Keywords: []string{"Deploy"}}, "bob", "please deploy it", true},
+		{Webhook{Keywords: []string{"deploy"}}, "bob", "hi", false},
+		{Webhook{From: []string{"alice"}, Keywords: []string{"deploy"}}, "bob", "deploy", false},
+	}
+	for _, tt := range tests {
+		if got := tt.hook.Match(tt.from, tt.txt); got != tt.want {
+			t.Errorf("%+v.Match(%q, %q) = %v, want %v", tt.hook, tt.from, tt.txt, got, tt.want)
+		}
+	}
+}
+
+// hookServer answers with statuses one by one, the last one is repeated
+type hookServer struct {
+	mu       sync.Mutex
+	statuses []int
+	body     string
+	requests int
+	sigs     []string
+	bodies   []string
+}
+
+func (hs *hookServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	dat, _ := ioutil.ReadAll(r.Body)
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	st := hs.statuses[len(hs.statuses)-1]
+	if hs.requests < len(hs.statuses) {
+		st = hs.statuses[hs.requests]
+	}
+	hs.requests++
+	hs.sigs = append(hs.sigs, r.Header.Get(signatureHdr))
+	hs.bodies = append(hs.bodies, string(dat))
+	w.WriteHeader(st)
+	if st == http.StatusOK {
+		w.Write([]byte(hs.body))
+	}
+}
+
+func newTestWebhooks() (*Webhooks, *[]string) {
+	var replies []string
+	ws := &Webhooks{
+		queues:     make(map[string]chan webhookPayload),
+		client:     &http.Client{Timeout: time.Second},
+		replies:    make(map[string][]time.Time),
+		retryDelay: time.Millisecond,
+	}
+	ws.Reply = func(chat, txt string) { replies = append(replies, chat+": "+txt) }
+	return ws, &replies
+}
+
+func TestWebhookDeliver(t *testing.T) {
+	errl = log.New(ioutil.Discard, "", 0)
+	store.SetMaxMessageLen(2048)
+	tests := []struct {
+		name     string
+		statuses []int
+		retries  int
+		requests int
+	}{
+		{"ok", []int{200}, 3, 1},
+		{"server errors are repeated", []int{500, 503, 200}, 3, 3},
+		{"too many requests is repeated", []int{429, 200}, 3, 2},
+		{"retries are limited", []int{500}, 2, 3},
+		{"client error isn't repeated", []int{400, 200}, 3, 1},
+	}
+	for _, tt := range tests {
+		hs := &hookServer{statuses: tt.statuses}
+		srv := httptest.NewServer(hs)
+		ws, _ := newTestWebhooks()
+		ws.deliver(Webhook{URL: srv.URL, Retries: tt.retries}, webhookPayload{ID: "1", From: "alice", Chat: "alice", Text: "hi"})
+		srv.Close()
+		if hs.requests != tt.requests {
+			t.Errorf("%s: %d requests, want %d", tt.name, hs.requests, tt.requests)
+		}
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	errl = log.New(ioutil.Discard, "", 0)
+	store.SetMaxMessageLen(2048)
+	hs := &hookServer{statuses: []int{200}}
+	srv := httptest.NewServer(hs)
+	defer srv.Close()
+	ws, _ := newTestWebhooks()
+	ws.deliver(Webhook{URL: srv.URL, Secret: "key"}, webhookPayload{ID: "1", From: "alice", Chat: "alice", Text: "hi"})
+	ws.deliver(Webhook{URL: srv.URL}, webhookPayload{ID: "2", From: "alice", Chat: "alice", Text: "hi"})
+	if hs.requests != 2 {
+		t.Fatalf("%d requests, want 2", hs.requests)
+	}
+	mac := hmac.New(sha256.New, []byte("key"))
+	mac.Write([]byte(hs.bodies[0]))
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); hs.sigs[0] != want {
+		t.Errorf("signature is %q, want %q", hs.sigs[0], want)
+	}
+	if hs.sigs[1] != "" {
+		t.Errorf("request without secret is signed: %q", hs.sigs[1])
+	}
+}
+
+func TestWebhookReply(t *testing.T) {
+	errl = log.New(ioutil.Discard, "", 0)
+	store.SetMaxMessageLen(2048)
+	hs := &hookServer{statuses: []int{200}, body: `{"reply": " pong "}`}
+	srv := httptest.NewServer(hs)
+	defer srv.Close()
+	ws, replies := newTestWebhooks()
+	p := webhookPayload{ID: "1", From: "alice", Chat: "alice", Text: "ping"}
+	ws.deliver(Webhook{URL: srv.URL}, p)
+	if len(*replies) != 0 {
+		t.Fatalf("reply is sent though replies are off: %v", *replies)
+	}
+	for i := 0; i < maxWebhookReplies+2; i++ {
+		ws.deliver(Webhook{URL: srv.URL, Replies: true}, p)
+	}
+	if len(*replies) != maxWebhookReplies {
+		t.Fatalf("%d replies are sent, want %d", len(*replies), maxWebhookReplies)
+	}
+	if (*replies)[0] != "alice: pong" {
+		t.Errorf("reply is %q, want %q", (*replies)[0], "alice: pong")
+	}
+}